@@ -0,0 +1,126 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Upload formats understood by the controller. Every controller accepts the
+// JSON map of FileEntry, newer ones advertise more in their status.
+const (
+	formatJSON  = "json"
+	formatTarGz = "tar.gz"
+)
+
+// Name of the first archive entry, it describes every file in the archive
+const archiveManifestName = ".fastpush-manifest.json"
+
+type manifestEntry struct {
+	Checksum string
+	Mode     os.FileMode
+	Size     int64
+	Link     string `json:",omitempty"`
+}
+
+// UploadFormat picks the most efficient upload format the controller supports
+func (c *FastPushPlugin) UploadFormat(client *ControllerClient) string {
+	for _, format := range client.Capabilities().UploadFormats {
+		if format == formatTarGz {
+			return formatTarGz
+		}
+	}
+	return formatJSON
+}
+
+/*
+*	writeBatchArchive streams a batch to w as a gzipped tar archive. The
+*	manifest comes first so the controller can verify paths, modes and
+*	checksums while it extracts the files that follow.
+ */
+func writeBatchArchive(w io.Writer, files map[string]*FileEntry, mapper *PathMapper, batch *uploadBatch) error {
+	manifest := map[string]*manifestEntry{}
+	for _, path := range batch.paths {
+		entry := files[path]
+		if entry.Link != "" {
+			manifest[path] = &manifestEntry{Checksum: entry.Checksum, Mode: os.ModeSymlink | 0777, Link: entry.Link}
+			continue
+		}
+		// Restored files carry their content, they are not on disk
+		size := int64(len(entry.Content))
+		if entry.Content == nil {
+			info, err := os.Stat(mapper.LocalPath(path))
+			if err != nil {
+				return err
+			}
+			size = info.Size()
+		}
+		// A mode of 0 is unknown, the controller keeps the one of an existing file
+		manifest[path] = &manifestEntry{Checksum: entry.Checksum, Mode: entry.Mode, Size: size}
+	}
+	manifestJSON, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	err = tw.WriteHeader(&tar.Header{
+		Name: archiveManifestName,
+		Mode: 0644,
+		Size: int64(len(manifestJSON)),
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := tw.Write(manifestJSON); err != nil {
+		return err
+	}
+	for _, path := range batch.paths {
+		if err := addArchiveFile(tw, path, mapper.LocalPath(path), manifest[path], files[path].Content); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func addArchiveFile(tw *tar.Writer, path string, localPath string, entry *manifestEntry, content []byte) error {
+	if entry.Link != "" {
+		return tw.WriteHeader(&tar.Header{
+			Name:     filepath.ToSlash(path),
+			Mode:     0777,
+			Typeflag: tar.TypeSymlink,
+			Linkname: entry.Link,
+		})
+	}
+	var r io.Reader = bytes.NewReader(content)
+	if content == nil {
+		f, err := os.Open(localPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	mode := entry.Mode.Perm()
+	if mode == 0 {
+		mode = 0644
+	}
+	err := tw.WriteHeader(&tar.Header{
+		Name: filepath.ToSlash(path),
+		Mode: int64(mode),
+		Size: entry.Size,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.CopyN(tw, r, entry.Size)
+	return err
+}
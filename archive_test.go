@@ -0,0 +1,87 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestWriteBatchArchive(t *testing.T) {
+	inTempDir(t, func() {
+		os.Mkdir("static", 0755)
+		if err := ioutil.WriteFile("static/index.html", []byte("<html/>"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		mapper, err := NewPathMapper([]PathMapping{{Local: "static", Remote: "/"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		files := map[string]*FileEntry{
+			"index.html": {Checksum: "1"},
+			"run.sh":     {Checksum: "2", Content: []byte("#!/bin/sh\n"), Mode: 0755},
+			"latest":     {Checksum: "3", Link: "run.sh"},
+		}
+		var buf bytes.Buffer
+		batch := &uploadBatch{paths: []string{"index.html", "latest", "run.sh"}}
+		if err := writeBatchArchive(&buf, files, mapper, batch); err != nil {
+			t.Fatal(err)
+		}
+
+		gz, err := gzip.NewReader(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tr := tar.NewReader(gz)
+		names := []string{}
+		contents := map[string]string{}
+		var manifest map[string]*manifestEntry
+		for {
+			header, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			names = append(names, header.Name)
+			data, _ := ioutil.ReadAll(tr)
+			switch {
+			case header.Name == archiveManifestName:
+				if err := json.Unmarshal(data, &manifest); err != nil {
+					t.Fatal(err)
+				}
+			case header.Typeflag == tar.TypeSymlink:
+				contents[header.Name] = "-> " + header.Linkname
+			default:
+				contents[header.Name] = string(data)
+				// Unknown modes are sent as 0644 and left unknown in the manifest
+				if want := map[string]int64{"index.html": 0644, "run.sh": 0755}[header.Name]; header.Mode != want {
+					t.Errorf("%s has mode %o, want %o", header.Name, header.Mode, want)
+				}
+			}
+		}
+
+		if want := []string{archiveManifestName, "index.html", "latest", "run.sh"}; !reflect.DeepEqual(names, want) {
+			t.Errorf("got entries %v, want %v", names, want)
+		}
+		wantContents := map[string]string{"index.html": "<html/>", "latest": "-> run.sh", "run.sh": "#!/bin/sh\n"}
+		if !reflect.DeepEqual(contents, wantContents) {
+			t.Errorf("got contents %v, want %v", contents, wantContents)
+		}
+		wantManifest := map[string]*manifestEntry{
+			"index.html": {Checksum: "1", Size: 7},
+			"latest":     {Checksum: "3", Mode: os.ModeSymlink | 0777, Link: "run.sh"},
+			"run.sh":     {Checksum: "2", Mode: 0755, Size: 10},
+		}
+		if !reflect.DeepEqual(manifest, wantManifest) {
+			got, _ := json.Marshal(manifest)
+			t.Errorf("got manifest %s", got)
+		}
+	})
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"code.cloudfoundry.org/cli/plugin"
+	"code.cloudfoundry.org/cli/plugin/models"
+	"github.com/parnurzeal/gorequest"
+	"github.com/xiwenc/cf-fastpush-controller/lib"
+)
+
+// Name of the app environment variable holding a secret shared with the controller
+const sharedSecretEnv = "FASTPUSH_SECRET"
+
+// Credentials is the header proving to the controller who is calling
+type Credentials struct {
+	Header string
+	Value  string
+}
+
+// ControllerClient builds authenticated requests for the controller of one app
+type ControllerClient struct {
+	Endpoint    string
+	AppURL      string
+	Credentials Credentials
+	TLSConfig   *tls.Config
+	AppGuid     string
+	// Instance pins requests to one app instance, anyInstance lets the router pick
+	Instance int
+	// Instances lists the indexes of the running instances that can be pinned
+	Instances []int
+}
+
+func (c *FastPushPlugin) NewControllerClient(cliConnection plugin.CliConnection, appName string, opts ControllerOptions) (*ControllerClient, error) {
+	app, err := cliConnection.GetApp(appName)
+	if err != nil {
+		return nil, NewError(ErrAppNotFound, err, "Could not find app %s", appName)
+	}
+	credentials, err := c.GetCredentials(cliConnection, app)
+	if err != nil {
+		return nil, err
+	}
+	apiEndpoint, err := c.GetApiEndpoint(app, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkCredentialTransport(credentials, apiEndpoint, opts); err != nil {
+		return nil, err
+	}
+	appURL, err := c.GetAppURL(app, opts)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig, err := c.TLSConfig(cliConnection, opts)
+	if err != nil {
+		return nil, err
+	}
+	client := &ControllerClient{
+		Endpoint:    apiEndpoint,
+		AppURL:      appURL,
+		Credentials: credentials,
+		TLSConfig:   tlsConfig,
+		AppGuid:     app.Guid,
+		Instance:    anyInstance,
+	}
+	if routedByGorouter(app, opts) {
+		client.Instances = runningInstances(app)
+	}
+	return client, nil
+}
+
+/*
+*	GetCredentials prefers a shared secret set in the app environment. Without
+*	one the user's OAuth access token is sent, the controller then verifies with
+*	Cloud Controller that the caller is allowed to develop the app.
+ */
+func (c *FastPushPlugin) GetCredentials(cliConnection plugin.CliConnection, app plugin_models.GetAppModel) (Credentials, error) {
+	if secret, ok := app.EnvironmentVars[sharedSecretEnv].(string); ok && secret != "" {
+		return Credentials{Header: "x-auth-token", Value: secret}, nil
+	}
+	return accessTokenCredentials(cliConnection)
+}
+
+// RefreshCredentials replaces an access token that may have expired meanwhile
+func (c *FastPushPlugin) RefreshCredentials(cliConnection plugin.CliConnection, client *ControllerClient) error {
+	if client.Credentials.Header != "Authorization" {
+		return nil
+	}
+	credentials, err := accessTokenCredentials(cliConnection)
+	if err != nil {
+		return err
+	}
+	client.Credentials = credentials
+	return nil
+}
+
+/*
+*	checkCredentialTransport refuses to send the access token in cleartext, it
+*	grants everything the user may do on the platform. A controller on this
+*	machine is fine, elsewhere plain http needs the shared secret, which only
+*	unlocks the controller, or an explicit --allow-http-token.
+ */
+func checkCredentialTransport(credentials Credentials, endpoint string, opts ControllerOptions) error {
+	if credentials.Header != "Authorization" || opts.AllowHTTPToken {
+		return nil
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Scheme != "http" || isLoopback(u.Hostname()) {
+		return nil
+	}
+	return NewError(ErrGeneric, nil, "Refusing to send your CF access token over plain http to %s. Use https, set %s in the app environment or pass --allow-http-token", u.Host, sharedSecretEnv)
+}
+
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func accessTokenCredentials(cliConnection plugin.CliConnection) (Credentials, error) {
+	token, err := cliConnection.AccessToken()
+	if err != nil || token == "" {
+		return Credentials{}, NewError(ErrNotLoggedIn, err, "Could not retrieve an access token, please log in again")
+	}
+	return Credentials{Header: "Authorization", Value: token}, nil
+}
+
+func (cc *ControllerClient) Get(path string) *gorequest.SuperAgent {
+	return cc.prepare(gorequest.New().Get(cc.Endpoint + path))
+}
+
+func (cc *ControllerClient) Put(path string) *gorequest.SuperAgent {
+	return cc.prepare(gorequest.New().Put(cc.Endpoint + path))
+}
+
+func (cc *ControllerClient) Delete(path string) *gorequest.SuperAgent {
+	return cc.prepare(gorequest.New().Delete(cc.Endpoint + path))
+}
+
+// GetApp requests a URL of the app itself, relative paths are resolved
+// against AppURL. The controller credentials are not sent along.
+func (cc *ControllerClient) GetApp(target string) *gorequest.SuperAgent {
+	if !strings.Contains(target, "://") {
+		target = cc.AppURL + "/" + strings.TrimLeft(target, "/")
+	}
+	return cc.route(gorequest.New().Get(target))
+}
+
+func (cc *ControllerClient) prepare(request *gorequest.SuperAgent) *gorequest.SuperAgent {
+	return cc.route(request).Set(cc.Credentials.Header, cc.Credentials.Value)
+}
+
+// route applies the TLS settings and pins the request to the client instance
+func (cc *ControllerClient) route(request *gorequest.SuperAgent) *gorequest.SuperAgent {
+	if cc.TLSConfig != nil {
+		request = request.TLSClientConfig(cc.TLSConfig)
+	}
+	if cc.Instance != anyInstance {
+		request = request.Set(instanceHeader, fmt.Sprintf("%s:%d", cc.AppGuid, cc.Instance))
+	}
+	return request
+}
+
+/*
+*	Stream sends a request whose body is produced by write while it is sent,
+*	so large uploads never have to fit in memory. gorequest buffers bodies,
+*	plain net/http is used instead. An error of write is returned as is,
+*	request errors are returned in errs like gorequest does.
+ */
+func (cc *ControllerClient) Stream(method string, path string, header http.Header, write func(io.Writer) error) (gorequest.Response, string, []error, error) {
+	reader, writer := io.Pipe()
+	written := make(chan error, 1)
+	go func() {
+		err := write(writer)
+		writer.CloseWithError(err)
+		written <- err
+	}()
+
+	request, err := http.NewRequest(method, cc.Endpoint+path, reader)
+	if err != nil {
+		reader.Close()
+		<-written
+		return nil, "", []error{err}, nil
+	}
+	for key, values := range header {
+		request.Header[key] = values
+	}
+	response, err := cc.do(request)
+	// Unblocks the writer when the request ended before the body was consumed
+	reader.Close()
+	if werr := <-written; werr != nil && werr != io.ErrClosedPipe {
+		return nil, "", nil, werr
+	}
+	if err != nil {
+		return nil, "", []error{err}, nil
+	}
+	defer response.Body.Close()
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, "", []error{err}, nil
+	}
+	return response, string(body), nil, nil
+}
+
+// do sends a plain net/http request the way prepare sets up gorequest ones
+func (cc *ControllerClient) do(request *http.Request) (*http.Response, error) {
+	request.Header.Set(cc.Credentials.Header, cc.Credentials.Value)
+	if cc.Instance != anyInstance {
+		request.Header.Set(instanceHeader, fmt.Sprintf("%s:%d", cc.AppGuid, cc.Instance))
+	}
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: cc.TLSConfig}}
+	return client.Do(request)
+}
+
+func (cc *ControllerClient) Status() (*lib.Status, error) {
+	response, body, errs := cc.Get("/status").End()
+	if err := checkResponse(response, errs, "retrieving status"); err != nil {
+		return nil, err
+	}
+	status := &lib.Status{}
+	if err := json.Unmarshal([]byte(body), status); err != nil {
+		return nil, NewError(ErrDecode, err, "Could not decode status from the fast-push controller")
+	}
+	return status, nil
+}
+
+// Features a controller lists in its status, older controllers list none
+const (
+	featureModes    = "modes"
+	featureSymlinks = "symlinks"
+)
+
+// controllerCapabilities is decoded from the same /status document as lib.Status
+type controllerCapabilities struct {
+	UploadFormats []string
+	Features      []string
+}
+
+func (caps *controllerCapabilities) supports(feature string) bool {
+	for _, f := range caps.Features {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// Capabilities tells what the controller supports beyond the JSON upload of
+// plain files. A status that cannot be read means nothing more.
+func (cc *ControllerClient) Capabilities() *controllerCapabilities {
+	capabilities := &controllerCapabilities{}
+	response, body, errs := cc.Get("/status").End()
+	if checkResponse(response, errs, "retrieving status") != nil {
+		return capabilities
+	}
+	if json.Unmarshal([]byte(body), capabilities) != nil {
+		return &controllerCapabilities{}
+	}
+	return capabilities
+}
+
+func (cc *ControllerClient) ListFiles() (map[string]*FileEntry, error) {
+	response, body, errs := cc.Get("/files").End()
+	if err := checkResponse(response, errs, "retrieving filelist"); err != nil {
+		return nil, err
+	}
+	files := map[string]*FileEntry{}
+	if err := json.Unmarshal([]byte(body), &files); err != nil {
+		return nil, NewError(ErrDecode, err, "Could not decode filelist from the fast-push controller")
+	}
+	return files, nil
+}
+
+// FileContent downloads the current content of one remote file
+func (cc *ControllerClient) FileContent(path string) ([]byte, error) {
+	response, body, errs := cc.Get(filePath(path)).EndBytes()
+	if err := checkResponse(response, errs, "downloading "+path); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+// OpenFile streams the current content of one remote file, which does not
+// have to fit in memory like with FileContent. The caller closes it.
+func (cc *ControllerClient) OpenFile(path string) (io.ReadCloser, error) {
+	action := "downloading " + path
+	request, err := http.NewRequest("GET", cc.Endpoint+filePath(path), nil)
+	if err != nil {
+		return nil, checkResponse(nil, []error{err}, action)
+	}
+	response, err := cc.do(request)
+	if err != nil {
+		return nil, checkResponse(nil, []error{err}, action)
+	}
+	if err := checkResponse(response, nil, action); err != nil {
+		response.Body.Close()
+		return nil, err
+	}
+	return response.Body, nil
+}
+
+// filePath is the controller path of one remote file
+func filePath(path string) string {
+	segments := strings.Split(filepath.ToSlash(path), "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return "/files/" + strings.Join(segments, "/")
+}
@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func TestCheckCredentialTransport(t *testing.T) {
+	token := Credentials{Header: "Authorization", Value: "bearer x"}
+	secret := Credentials{Header: "x-auth-token", Value: "s3cret"}
+	for _, c := range []struct {
+		credentials Credentials
+		endpoint    string
+		opts        ControllerOptions
+		allowed     bool
+	}{
+		{token, "https://app.example.com/_fastpush", ControllerOptions{}, true},
+		{token, "http://app.example.com/_fastpush", ControllerOptions{}, false},
+		{token, "http://tcp.example.com:61001/_fastpush", ControllerOptions{}, false},
+		{token, "http://app.example.com/_fastpush", ControllerOptions{AllowHTTPToken: true}, true},
+		{token, "http://localhost:9000/_fastpush", ControllerOptions{}, true},
+		{token, "http://127.0.0.1:9000/_fastpush", ControllerOptions{}, true},
+		{token, "http://[::1]:9000/_fastpush", ControllerOptions{}, true},
+		{secret, "http://app.example.com/_fastpush", ControllerOptions{}, true},
+	} {
+		err := checkCredentialTransport(c.credentials, c.endpoint, c.opts)
+		if (err == nil) != c.allowed {
+			t.Errorf("%s to %s with %+v: got %v", c.credentials.Header, c.endpoint, c.opts, err)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/simonleung8/flags"
+	"gopkg.in/yaml.v2"
+)
+
+// Project configuration file, read from the working directory
+const projectConfigFile = ".fastpush.yml"
+
+/*
+*	ProjectConfig holds per project defaults so they do not have to be repeated
+*	on every command. Command line flags take precedence over it.
+ */
+type ProjectConfig struct {
+	Controller   ControllerOptions `yaml:",inline"`
+	RestartRules []RestartRule     `yaml:"restart_rules"`
+	PathMappings []PathMapping     `yaml:"path_mappings"`
+	// What to do with symlinks leaving the app root, see ScanOptions
+	ExternalSymlinks string `yaml:"external_symlinks"`
+	// Size of the push history in megabytes, 0 keeps no history
+	HistoryLimitMB int `yaml:"history_limit_mb"`
+}
+
+func LoadProjectConfig() (*ProjectConfig, error) {
+	config := &ProjectConfig{ExternalSymlinks: symlinksFollow, HistoryLimitMB: defaultHistoryLimitMB}
+	data, err := ioutil.ReadFile(projectConfigFile)
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, NewError(ErrGeneric, err, "Could not parse %s", projectConfigFile)
+	}
+	for _, rule := range config.RestartRules {
+		if err := rule.validate(); err != nil {
+			return nil, NewError(ErrGeneric, err, "Invalid restart rule in %s", projectConfigFile)
+		}
+	}
+	if err := validSymlinkPolicy(config.ExternalSymlinks); err != nil {
+		return nil, NewError(ErrGeneric, err, "Invalid external_symlinks in %s", projectConfigFile)
+	}
+	if config.HistoryLimitMB < 0 {
+		return nil, NewError(ErrGeneric, nil, "Invalid history_limit_mb in %s, use 0 to keep no history", projectConfigFile)
+	}
+	for _, mapping := range config.PathMappings {
+		if err := mapping.validate(); err != nil {
+			return nil, NewError(ErrGeneric, err, "Invalid path mapping in %s", projectConfigFile)
+		}
+	}
+	return config, nil
+}
+
+// Help texts of the flags added by addControllerFlags
+var controllerFlagUsage = map[string]string{
+	"endpoint":         "--endpoint, controller host[:port] or URL to use instead of the app route",
+	"scheme":           "--scheme, http or https (default https)",
+	"prefix":           "--prefix, path prefix of the controller API (default /_fastpush)",
+	"ca-cert":          "--ca-cert, PEM bundle of CA certificates to trust for the controller",
+	"client-cert":      "--client-cert, PEM client certificate for mutual TLS",
+	"client-key":       "--client-key, PEM private key of the client certificate",
+	"allow-http-token": "--allow-http-token, send the CF access token to a controller reached over plain http",
+}
+
+func withControllerUsage(options map[string]string) map[string]string {
+	for name, usage := range controllerFlagUsage {
+		options[name] = usage
+	}
+	// Every command that takes the controller flags also works on a local root
+	options["path"] = pathFlagUsage
+	return options
+}
+
+func addControllerFlags(fc flags.FlagContext) {
+	fc.NewStringFlag("endpoint", "", "controller host[:port] or URL, instead of the app route")
+	fc.NewStringFlag("scheme", "", "scheme used to reach the controller (http or https)")
+	fc.NewStringFlag("prefix", "", "path prefix of the controller API (default /_fastpush)")
+	fc.NewStringFlag("ca-cert", "", "PEM bundle of CA certificates to trust for the controller")
+	fc.NewStringFlag("client-cert", "", "PEM client certificate for mutual TLS")
+	fc.NewStringFlag("client-key", "", "PEM private key of the client certificate")
+	fc.NewBoolFlag("allow-http-token", "", "send the CF access token to a controller reached over plain http")
+}
+
+// controllerOptions merges the controller flags over the project defaults.
+// Certificate paths are made absolute, commands change into the app root
+// before they connect.
+func controllerOptions(fc flags.FlagContext, defaults ControllerOptions) ControllerOptions {
+	opts := defaults
+	if fc.IsSet("endpoint") {
+		opts.Endpoint = fc.String("endpoint")
+	}
+	if fc.IsSet("scheme") {
+		opts.Scheme = fc.String("scheme")
+	}
+	if fc.IsSet("prefix") {
+		opts.Prefix = fc.String("prefix")
+	}
+	if fc.IsSet("ca-cert") {
+		opts.CACert = fc.String("ca-cert")
+	}
+	if fc.IsSet("client-cert") {
+		opts.ClientCert = fc.String("client-cert")
+	}
+	if fc.IsSet("client-key") {
+		opts.ClientKey = fc.String("client-key")
+	}
+	if fc.Bool("allow-http-token") {
+		opts.AllowHTTPToken = true
+	}
+	opts.CACert = absolutePath(opts.CACert)
+	opts.ClientCert = absolutePath(opts.ClientCert)
+	opts.ClientKey = absolutePath(opts.ClientKey)
+	return opts
+}
+
+// absolutePath resolves p against the working directory, "" stays empty
+func absolutePath(p string) string {
+	if p == "" {
+		return p
+	}
+	if abs, err := filepath.Abs(p); err == nil {
+		return abs
+	}
+	return p
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+/*
+*	Baseline is what the remote files of an app looked like after our last
+*	push, by path. A remote file that no longer matches it was changed by
+*	someone else since, overwriting it would lose their work.
+*
+*	A push that failed on some instances leaves them with the previous files,
+*	Stale holds those states so they are not taken for remote changes. The
+*	empty state stands for a deleted path.
+ */
+type Baseline struct {
+	file  string
+	known bool
+	Files map[string]string
+	Stale map[string][]string `json:",omitempty"`
+}
+
+func LoadBaseline(appGuid string) *Baseline {
+	b := &Baseline{file: filepath.Join(appStateDir(appGuid), "baseline.json"), Files: map[string]string{}, Stale: map[string][]string{}}
+	data, err := ioutil.ReadFile(b.file)
+	if err != nil {
+		return b
+	}
+	if json.Unmarshal(data, b) == nil {
+		b.known = true
+	}
+	if b.Files == nil {
+		b.Files = map[string]string{}
+	}
+	if b.Stale == nil {
+		b.Stale = map[string][]string{}
+	}
+	return b
+}
+
+// Save records the baseline, like the checksum cache failing to do so is not fatal
+func (b *Baseline) Save() {
+	data, err := json.Marshal(b)
+	if err != nil || os.MkdirAll(filepath.Dir(b.file), 0755) != nil {
+		return
+	}
+	if ioutil.WriteFile(b.file+".tmp", data, 0644) == nil {
+		os.Rename(b.file+".tmp", b.file)
+	}
+}
+
+// Copy returns a baseline to record a push in, while b still holds the state
+// the other instances are checked against
+func (b *Baseline) Copy() *Baseline {
+	copied := &Baseline{file: b.file, known: b.known, Files: map[string]string{}, Stale: map[string][]string{}}
+	for path, fp := range b.Files {
+		copied.Files[path] = fp
+	}
+	for path, states := range b.Stale {
+		copied.Stale[path] = append([]string{}, states...)
+	}
+	return copied
+}
+
+// Record sets the state of a path after a push, nil when it no longer exists.
+// The instance now matches it, older states no longer need to be accepted.
+func (b *Baseline) Record(path string, entry *FileEntry) {
+	delete(b.Stale, path)
+	if entry == nil {
+		delete(b.Files, path)
+		return
+	}
+	b.Files[path] = fingerprint(entry)
+}
+
+// KeepStale accepts the states of previous as well, for the instances that a
+// push failed on and that may still hold them
+func (b *Baseline) KeepStale(previous *Baseline) {
+	paths := map[string]bool{}
+	for _, files := range []map[string]string{b.Files, previous.Files} {
+		for path := range files {
+			paths[path] = true
+		}
+	}
+	for path := range previous.Stale {
+		paths[path] = true
+	}
+	for path := range paths {
+		b.accept(path, previous.Files[path])
+		for _, state := range previous.Stale[path] {
+			b.accept(path, state)
+		}
+	}
+}
+
+func (b *Baseline) accept(path, state string) {
+	if state == b.Files[path] {
+		return
+	}
+	for _, stale := range b.Stale[path] {
+		if stale == state {
+			return
+		}
+	}
+	b.Stale[path] = append(b.Stale[path], state)
+}
+
+// changedRemotely tells whether the remote file differs from the baseline.
+// Without a baseline, on the first push, nothing is known to have changed.
+func (b *Baseline) changedRemotely(path string, remote *FileEntry) bool {
+	if !b.known {
+		return false
+	}
+	state := ""
+	if remote != nil {
+		state = fingerprint(remote)
+	}
+	if state == b.Files[path] {
+		return false
+	}
+	for _, stale := range b.Stale[path] {
+		if stale == state {
+			return false
+		}
+	}
+	return true
+}
+
+// Modes are left out, not every controller lists them
+func fingerprint(entry *FileEntry) string {
+	if entry.Link != "" {
+		return "link:" + entry.Link
+	}
+	return entry.Checksum
+}
+
+/*
+*	FindConflicts returns the paths the plan would overwrite or delete although
+*	they changed remotely since the baseline, sorted.
+ */
+func FindConflicts(baseline *Baseline, remote map[string]*FileEntry, plan *PushPlan) []string {
+	conflicts := []string{}
+	for _, path := range plan.Paths() {
+		if baseline.changedRemotely(path, remote[path]) {
+			conflicts = append(conflicts, path)
+		}
+	}
+	sort.Strings(conflicts)
+	return conflicts
+}
+
+// What to do with conflicting files
+const (
+	conflictsOverwrite = "overwrite"
+	conflictsSkip      = "skip"
+	conflictsAbort     = "abort"
+)
+
+/*
+*	resolveConflicts lists the conflicts and asks what to do with them when
+*	someone is there to answer. Without --force a push that cannot ask is
+*	aborted.
+ */
+func (c *FastPushPlugin) resolveConflicts(conflicts []string, opts FastPushOptions) string {
+	for _, path := range conflicts {
+		c.ui.Say("[CONFLICT] " + path + " (changed remotely since the last push)")
+	}
+	if opts.Force {
+		c.ui.Warn("warning: overwriting %d remotely changed file(s), --force is set", len(conflicts))
+		return conflictsOverwrite
+	}
+	if !isInteractive() {
+		return conflictsAbort
+	}
+	for {
+		answer := strings.ToLower(strings.TrimSpace(c.ui.Ask("Overwrite the remote changes? [o]verwrite, [s]kip these files, [a]bort")))
+		switch answer {
+		case "o", "overwrite":
+			return conflictsOverwrite
+		case "s", "skip":
+			return conflictsSkip
+		case "", "a", "abort":
+			return conflictsAbort
+		}
+	}
+}
+
+// skipPaths drops paths from the upload and the plan
+func skipPaths(files map[string]*FileEntry, plan *PushPlan, paths []string) {
+	skip := map[string]bool{}
+	for _, path := range paths {
+		skip[path] = true
+		delete(files, path)
+		plan.Bytes -= plan.Sizes[path]
+		delete(plan.Sizes, path)
+	}
+	keep := func(list []string) []string {
+		kept := []string{}
+		for _, path := range list {
+			if !skip[path] {
+				kept = append(kept, path)
+			}
+		}
+		return kept
+	}
+	plan.New = keep(plan.New)
+	plan.Modified = keep(plan.Modified)
+	plan.Deleted = keep(plan.Deleted)
+}
+
+// Prompts only make sense when stdin is a terminal, not in CI or a pipe
+func isInteractive() bool {
+	info, err := os.Stdin.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestKeepStaleAcceptsFilesOfFailedInstances(t *testing.T) {
+	previous := &Baseline{known: true, Files: map[string]string{"a": "1", "gone": "2"}, Stale: map[string][]string{}}
+	next := previous.Copy()
+	// The push reached one instance only
+	next.Record("a", &FileEntry{Checksum: "3"})
+	next.Record("gone", nil)
+	next.Record("new", &FileEntry{Checksum: "4"})
+	next.KeepStale(previous)
+
+	for _, c := range []struct {
+		path   string
+		remote *FileEntry
+	}{
+		{"a", &FileEntry{Checksum: "1"}},
+		{"a", &FileEntry{Checksum: "3"}},
+		{"gone", &FileEntry{Checksum: "2"}},
+		{"gone", nil},
+		{"new", nil},
+		{"new", &FileEntry{Checksum: "4"}},
+	} {
+		if next.changedRemotely(c.path, c.remote) {
+			t.Errorf("%s %v reported as changed remotely", c.path, c.remote)
+		}
+	}
+	if !next.changedRemotely("a", &FileEntry{Checksum: "5"}) {
+		t.Errorf("a changed by someone else is not reported")
+	}
+
+	// Once every instance got a path its old states are dropped
+	next.Record("a", &FileEntry{Checksum: "3"})
+	if !next.changedRemotely("a", &FileEntry{Checksum: "1"}) {
+		t.Errorf("stale state of a still accepted after a complete push")
+	}
+}
+
+func TestFindConflicts(t *testing.T) {
+	baseline := &Baseline{known: true, Files: map[string]string{
+		"same.txt":    "1",
+		"edited.txt":  "2",
+		"removed.txt": "3",
+		"link":        "link:same.txt",
+	}, Stale: map[string][]string{}}
+	remote := map[string]*FileEntry{
+		"same.txt":   {Checksum: "1"},
+		"edited.txt": {Checksum: "changed"},
+		"added.txt":  {Checksum: "4"},
+		"link":       {Link: "edited.txt"},
+		// Not part of the plan, never a conflict
+		"untouched.txt": {Checksum: "changed"},
+	}
+	plan := &PushPlan{
+		New:      []string{"added.txt", "brand-new.txt"},
+		Modified: []string{"same.txt", "edited.txt", "link"},
+		Deleted:  []string{"removed.txt"},
+	}
+	got := FindConflicts(baseline, remote, plan)
+	want := []string{"added.txt", "edited.txt", "link", "removed.txt"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got conflicts %v, want %v", got, want)
+	}
+
+	// The first push of an app has nothing to compare with
+	first := &Baseline{Files: map[string]string{}, Stale: map[string][]string{}}
+	if got := FindConflicts(first, remote, plan); len(got) != 0 {
+		t.Errorf("first push reported conflicts %v", got)
+	}
+}
+
+func TestBaselineRoundTrip(t *testing.T) {
+	inTempDir(t, func() {
+		if LoadBaseline("guid").known {
+			t.Fatalf("a missing baseline is known")
+		}
+		b := LoadBaseline("guid")
+		b.Record("a.txt", &FileEntry{Checksum: "1"})
+		b.Record("link", &FileEntry{Checksum: "ignored", Link: "a.txt"})
+		b.Stale["b.txt"] = []string{""}
+		b.Save()
+
+		if _, err := os.Stat(".fastpush"); !os.IsNotExist(err) {
+			t.Errorf("the baseline was saved in the app root")
+		}
+		loaded := LoadBaseline("guid")
+		if !loaded.known {
+			t.Fatalf("saved baseline is not known")
+		}
+		if !reflect.DeepEqual(loaded.Files, b.Files) || !reflect.DeepEqual(loaded.Stale, b.Stale) {
+			t.Errorf("got %v %v, want %v %v", loaded.Files, loaded.Stale, b.Files, b.Stale)
+		}
+		if LoadBaseline("other").known {
+			t.Errorf("baselines are not kept per app")
+		}
+	})
+}
+
+func TestSkipPaths(t *testing.T) {
+	files := map[string]*FileEntry{"a": {}, "b": {}}
+	plan := &PushPlan{New: []string{"a"}, Modified: []string{"b"}, Deleted: []string{"c"}, Bytes: 5, Sizes: map[string]int64{"a": 2, "b": 3}}
+	skipPaths(files, plan, []string{"a", "c"})
+	if len(files) != 1 || files["b"] == nil {
+		t.Errorf("got files %v", files)
+	}
+	if len(plan.New) != 0 || len(plan.Deleted) != 0 || !reflect.DeepEqual(plan.Modified, []string{"b"}) || plan.Bytes != 3 {
+		t.Errorf("got plan %+v", plan)
+	}
+}
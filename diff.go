@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// Lines of unchanged context around every hunk, like diff -u
+const diffContext = 3
+
+// Files with more changed lines are only reported as different, the edit
+// script needs memory growing with the square of that number
+const maxDiffEdits = 2000
+
+type DiffOptions struct {
+	Stat       bool
+	NameOnly   bool
+	Controller ControllerOptions
+}
+
+/*
+*	ShowDiff prints what a fast-push would change in the container, as unified
+*	diffs from the remote (a/) to the local (b/) version of every file. The
+*	remote contents are fetched from the first running instance.
+ */
+func (c *FastPushPlugin) ShowDiff(client *ControllerClient, opts DiffOptions) error {
+	target := client.InstanceClients()[0]
+	remote, err := target.ListFiles()
+	if err != nil {
+		return err
+	}
+	local, ignore, err := c.localFiles(target, false)
+	if err != nil {
+		return err
+	}
+	if local, err = c.paths.Map(local); err != nil {
+		return err
+	}
+	remote = ignore.Filter(remote)
+
+	paths := []string{}
+	for path := range differingPaths(remote, local) {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	if opts.NameOnly {
+		for _, path := range paths {
+			c.ui.Say("%s", path)
+		}
+		return nil
+	}
+
+	insertions, deletions := 0, 0
+	for _, path := range paths {
+		// Like git, the content of a symlink is its target
+		var before, after []byte
+		if remote[path] != nil && remote[path].Link != "" {
+			before = []byte(remote[path].Link)
+		} else if remote[path] != nil {
+			if before, err = target.FileContent(path); err != nil {
+				return err
+			}
+		}
+		if local[path] != nil && local[path].Link != "" {
+			after = []byte(local[path].Link)
+		} else if local[path] != nil {
+			if after, err = ioutil.ReadFile(c.paths.LocalPath(path)); err != nil {
+				return err
+			}
+		}
+		modeOnly := remote[path] != nil && local[path] != nil && bytes.Equal(before, after)
+		if modeOnly {
+			if opts.Stat {
+				c.ui.Say(" %s | 0 (%s)", path, modeChange(remote[path], local[path]))
+			} else {
+				c.ui.Say("diff --fastpush a/%s b/%s", path, path)
+				c.ui.Say("old mode %o", remote[path].Mode.Perm())
+				c.ui.Say("new mode %o", local[path].Mode.Perm())
+			}
+			continue
+		}
+
+		if isBinary(before) || isBinary(after) {
+			if opts.Stat {
+				c.ui.Say(" %s | Bin %d -> %d bytes", path, len(before), len(after))
+			} else {
+				c.ui.Say("Binary files %s and %s differ", diffName("a", path, remote[path]), diffName("b", path, local[path]))
+			}
+			continue
+		}
+
+		ops, ok := diffLines(splitLines(before), splitLines(after), maxDiffEdits)
+		if !ok {
+			if opts.Stat {
+				c.ui.Say(" %s | more than %d lines changed", path, maxDiffEdits)
+			} else {
+				c.ui.Say("Files %s and %s differ in more than %d lines", diffName("a", path, remote[path]), diffName("b", path, local[path]), maxDiffEdits)
+			}
+			continue
+		}
+		if opts.Stat {
+			added, removed := countChanges(ops)
+			insertions += added
+			deletions += removed
+			c.ui.Say(" %s | %d %s%s", path, added+removed, strings.Repeat("+", minInt(added, 40)), strings.Repeat("-", minInt(removed, 40)))
+			continue
+		}
+		c.ui.Say("diff --fastpush a/%s b/%s", path, path)
+		c.ui.Say("--- %s", diffName("a", path, remote[path]))
+		c.ui.Say("+++ %s", diffName("b", path, local[path]))
+		c.ui.Say("%s", strings.TrimSuffix(formatHunks(ops), "\n"))
+	}
+	if opts.Stat {
+		c.ui.Say(" %d files changed, %d insertions(+), %d deletions(-)", len(paths), insertions, deletions)
+	}
+	return nil
+}
+
+func diffName(prefix string, path string, entry *FileEntry) string {
+	if entry == nil {
+		return "/dev/null"
+	}
+	return prefix + "/" + path
+}
+
+// Same heuristic as git: a NUL byte in the first 8000 bytes means binary
+func isBinary(content []byte) bool {
+	if len(content) > 8000 {
+		content = content[:8000]
+	}
+	return bytes.IndexByte(content, 0) >= 0
+}
+
+func splitLines(content []byte) []string {
+	if len(content) == 0 {
+		return []string{}
+	}
+	return strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+}
+
+type diffOp struct {
+	kind byte // ' ', '-' or '+'
+	line string
+}
+
+/*
+*	diffLines computes the shortest edit script from a to b with the Myers
+*	algorithm. Only the diagonals reached in every step are kept, so memory
+*	grows with the square of the number of differences, not of the lines. It
+*	gives up, returning false, when more than maxEdits lines differ.
+ */
+func diffLines(a []string, b []string, maxEdits int) ([]diffOp, bool) {
+	n, m := len(a), len(b)
+	// New and deleted files need no search, whatever their size
+	if n == 0 || m == 0 {
+		ops := []diffOp{}
+		for _, line := range a {
+			ops = append(ops, diffOp{'-', line})
+		}
+		for _, line := range b {
+			ops = append(ops, diffOp{'+', line})
+		}
+		return ops, true
+	}
+	if n-m > maxEdits || m-n > maxEdits {
+		return nil, false
+	}
+	offset := n + m + 1
+	v := make([]int, 2*offset+1)
+	trace := [][]int{}
+	for d := 0; d <= n+m; d++ {
+		if d > maxEdits {
+			return nil, false
+		}
+		trace = append(trace, append([]int{}, v[offset-d:offset+d+1]...))
+		done := false
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				done = true
+				break
+			}
+		}
+		if done {
+			break
+		}
+	}
+
+	ops := []diffOp{}
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		// trace[d] holds the diagonals -d..d as they were before step d
+		prev := func(k int) int { return trace[d][k+d] }
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && prev(k-1) < prev(k+1)) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := 0
+		if d > 0 {
+			prevX = prev(prevK)
+		}
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			ops = append(ops, diffOp{' ', a[x-1]})
+			x--
+			y--
+		}
+		if d > 0 {
+			if x == prevX {
+				ops = append(ops, diffOp{'+', b[y-1]})
+				y--
+			} else {
+				ops = append(ops, diffOp{'-', a[x-1]})
+				x--
+			}
+		}
+	}
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops, true
+}
+
+func countChanges(ops []diffOp) (int, int) {
+	added, removed := 0, 0
+	for _, op := range ops {
+		switch op.kind {
+		case '+':
+			added++
+		case '-':
+			removed++
+		}
+	}
+	return added, removed
+}
+
+// formatHunks renders the edit script as unified diff hunks
+func formatHunks(ops []diffOp) string {
+	var out bytes.Buffer
+	// oldLine and newLine hold the 1 based line numbers before each op
+	oldLine := make([]int, len(ops)+1)
+	newLine := make([]int, len(ops)+1)
+	oldLine[0], newLine[0] = 1, 1
+	for i, op := range ops {
+		oldLine[i+1], newLine[i+1] = oldLine[i], newLine[i]
+		if op.kind != '+' {
+			oldLine[i+1]++
+		}
+		if op.kind != '-' {
+			newLine[i+1]++
+		}
+	}
+
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			i++
+			continue
+		}
+		start := maxInt(i-diffContext, 0)
+		end := i
+		// Extend the hunk while the next change is close enough to share context
+		for j := i; j < len(ops); j++ {
+			if ops[j].kind != ' ' {
+				end = j + 1
+			} else if j-end >= 2*diffContext {
+				break
+			}
+		}
+		end = minInt(end+diffContext, len(ops))
+
+		oldCount, newCount := 0, 0
+		for _, op := range ops[start:end] {
+			if op.kind != '+' {
+				oldCount++
+			}
+			if op.kind != '-' {
+				newCount++
+			}
+		}
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n", hunkRange(oldLine[start], oldCount), hunkRange(newLine[start], newCount))
+		for _, op := range ops[start:end] {
+			fmt.Fprintf(&out, "%c%s\n", op.kind, op.line)
+		}
+		i = end
+	}
+	return out.String()
+}
+
+// An empty range starts at the line before it, as in diff -u
+func hunkRange(start int, count int) string {
+	if count == 0 {
+		start--
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start)
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a int, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
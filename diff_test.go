@@ -0,0 +1,136 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func lines(s string) []string {
+	if s == "" {
+		return []string{}
+	}
+	return strings.Split(s, " ")
+}
+
+func mustDiff(a, b []string) []diffOp {
+	ops, _ := diffLines(a, b, maxDiffEdits)
+	return ops
+}
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		a, b    string
+		added   int
+		removed int
+	}{
+		{"", "", 0, 0},
+		{"a b c", "a b c", 0, 0},
+		{"", "a b", 2, 0},
+		{"a b", "", 0, 2},
+		{"a b c", "a x c", 1, 1},
+		{"a b c a b b a", "c b a b a c", 2, 3},
+	}
+	for _, test := range tests {
+		a, b := lines(test.a), lines(test.b)
+		ops, ok := diffLines(a, b, maxDiffEdits)
+		if !ok {
+			t.Fatalf("diffLines(%q, %q) gave up", test.a, test.b)
+		}
+		added, removed := countChanges(ops)
+		if added != test.added || removed != test.removed {
+			t.Errorf("diffLines(%q, %q): +%d -%d, want +%d -%d", test.a, test.b, added, removed, test.added, test.removed)
+		}
+		// Applying the script to a must give b
+		before, after := []string{}, []string{}
+		for _, op := range ops {
+			if op.kind != '+' {
+				before = append(before, op.line)
+			}
+			if op.kind != '-' {
+				after = append(after, op.line)
+			}
+		}
+		if strings.Join(before, " ") != test.a || strings.Join(after, " ") != test.b {
+			t.Errorf("diffLines(%q, %q) produced %q -> %q", test.a, test.b, before, after)
+		}
+	}
+}
+
+func TestFormatHunks(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want string
+	}{
+		{
+			"single change",
+			"1 2 3 4 5 6 7 8 9",
+			"1 2 3 4 x 6 7 8 9",
+			"@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+x\n 6\n 7\n 8\n",
+		},
+		{
+			"insert into an empty file",
+			"",
+			"a",
+			"@@ -0,0 +1 @@\n+a\n",
+		},
+		{
+			"delete everything",
+			"a b",
+			"",
+			"@@ -1,2 +0,0 @@\n-a\n-b\n",
+		},
+		{
+			"close changes share a hunk",
+			"1 2 3 4 5 6 7",
+			"x 2 3 4 5 6 y",
+			"@@ -1,7 +1,7 @@\n-1\n+x\n 2\n 3\n 4\n 5\n 6\n-7\n+y\n",
+		},
+		{
+			"distant changes get their own hunks",
+			"1 2 3 4 5 6 7 8 9 10 11 12",
+			"x 2 3 4 5 6 7 8 9 10 11 y",
+			"@@ -1,4 +1,4 @@\n-1\n+x\n 2\n 3\n 4\n@@ -9,4 +9,4 @@\n 9\n 10\n 11\n-12\n+y\n",
+		},
+	}
+	for _, test := range tests {
+		if got := formatHunks(mustDiff(lines(test.a), lines(test.b))); got != test.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", test.name, got, test.want)
+		}
+	}
+}
+
+func TestHunkRange(t *testing.T) {
+	tests := []struct {
+		start, count int
+		want         string
+	}{
+		{1, 1, "1"},
+		{3, 4, "3,4"},
+		{1, 0, "0,0"},
+		{5, 0, "4,0"},
+	}
+	for _, test := range tests {
+		if got := hunkRange(test.start, test.count); got != test.want {
+			t.Errorf("hunkRange(%d, %d) = %q, want %q", test.start, test.count, got, test.want)
+		}
+	}
+}
+
+func TestDiffLinesGivesUp(t *testing.T) {
+	// 3 lines removed and 3 added
+	a, b := lines("a b c d e f"), lines("a x y z e f")
+	if _, ok := diffLines(a, b, 6); !ok {
+		t.Errorf("6 changed lines rejected with a limit of 6")
+	}
+	if _, ok := diffLines(a, b, 5); ok {
+		t.Errorf("6 changed lines accepted with a limit of 5")
+	}
+	if _, ok := diffLines(lines("a"), lines("a b c d e f g"), 5); ok {
+		t.Errorf("6 added lines accepted with a limit of 5")
+	}
+	// Whole files added or removed are never too large
+	if ops, ok := diffLines(lines(""), lines("a b c d e f"), 2); !ok || len(ops) != 6 {
+		t.Errorf("new file: got %v, %v", ops, ok)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"strconv"
+)
+
+type instanceDrift struct {
+	index  int
+	health string
+	files  map[string]*FileEntry
+	err    error
+}
+
+/*
+*	ShowDrift prints the state of every instance: its health, the number of
+*	files it holds and how many of them differ from the local tree and from
+*	the other instances. Instances that missed a push or lost their changes
+*	in a restart stand out this way.
+ */
+func (c *FastPushPlugin) ShowDrift(client *ControllerClient) error {
+	local, ignore, err := c.localFiles(client, false)
+	if err != nil {
+		return err
+	}
+	if local, err = c.paths.Map(local); err != nil {
+		return err
+	}
+
+	instances := []*instanceDrift{}
+	for _, target := range client.InstanceClients() {
+		drift := &instanceDrift{index: target.Instance}
+		status, err := target.Status()
+		if err == nil {
+			drift.health = status.Health
+			drift.files, err = target.ListFiles()
+		}
+		if err == nil {
+			drift.files = ignore.Filter(drift.files)
+		}
+		drift.err = err
+		instances = append(instances, drift)
+	}
+
+	table := c.ui.Table([]string{"instance", "health", "files", "differ from local", "differ from others"})
+	failed := 0
+	kind := ErrGeneric
+	for _, drift := range instances {
+		index := strconv.Itoa(drift.index)
+		if drift.index == anyInstance {
+			index = "-"
+		}
+		instance := &Event{Type: "status"}
+		if drift.index != anyInstance {
+			instance.Instance = intValue(drift.index)
+		}
+		if drift.err != nil {
+			if failed == 0 {
+				kind = ErrorKind(ExitCode(drift.err))
+			}
+			failed++
+			table.Add(index, drift.err.Error(), "-", "-", "-")
+			instance.Error = errorReport(drift.err)
+			c.report.Event(instance)
+			continue
+		}
+		others := map[string]bool{}
+		for _, other := range instances {
+			if other != drift && other.err == nil {
+				for path := range differingPaths(drift.files, other.files) {
+					others[path] = true
+				}
+			}
+		}
+		differLocal := len(differingPaths(drift.files, local))
+		table.Add(index, drift.health,
+			strconv.Itoa(len(drift.files)),
+			strconv.Itoa(differLocal),
+			strconv.Itoa(len(others)))
+		instance.Health = drift.health
+		instance.Files = intValue(len(drift.files))
+		instance.DifferLocal = intValue(differLocal)
+		instance.DifferOthers = intValue(len(others))
+		c.report.Event(instance)
+	}
+	table.Print()
+	if failed > 0 {
+		return NewError(kind, nil, "Could not get the state of %d of %d instances", failed, len(instances))
+	}
+	return nil
+}
+
+// differingPaths returns the paths missing on one side or with other content or mode
+func differingPaths(a map[string]*FileEntry, b map[string]*FileEntry) map[string]bool {
+	paths := map[string]bool{}
+	for path, f := range a {
+		if b[path] == nil || !sameFile(f, b[path]) {
+			paths[path] = true
+		}
+	}
+	for path := range b {
+		if a[path] == nil {
+			paths[path] = true
+		}
+	}
+	return paths
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+
+	"code.cloudfoundry.org/cli/cf/terminal"
+	"github.com/parnurzeal/gorequest"
+)
+
+/*
+*	ErrorKind classifies the failures of the plugin. The value of each kind is
+*	also the exit code of the plugin so scripts can branch on it.
+ */
+type ErrorKind int
+
+const (
+	ErrGeneric               ErrorKind = 1
+	ErrNotLoggedIn           ErrorKind = 2
+	ErrAppNotFound           ErrorKind = 3
+	ErrNoRoute               ErrorKind = 4
+	ErrControllerUnreachable ErrorKind = 5
+	ErrAuthRejected          ErrorKind = 6
+	ErrUnexpectedStatus      ErrorKind = 7
+	ErrDecode                ErrorKind = 8
+	ErrCertificate           ErrorKind = 9
+	ErrUnhealthy             ErrorKind = 10
+	ErrConflict              ErrorKind = 11
+)
+
+var errorKindNames = map[ErrorKind]string{
+	ErrGeneric:               "generic",
+	ErrNotLoggedIn:           "not_logged_in",
+	ErrAppNotFound:           "app_not_found",
+	ErrNoRoute:               "no_route",
+	ErrControllerUnreachable: "controller_unreachable",
+	ErrAuthRejected:          "auth_rejected",
+	ErrUnexpectedStatus:      "unexpected_status",
+	ErrDecode:                "decode",
+	ErrCertificate:           "certificate",
+	ErrUnhealthy:             "unhealthy",
+	ErrConflict:              "conflict",
+}
+
+func (k ErrorKind) String() string {
+	if name, ok := errorKindNames[k]; ok {
+		return name
+	}
+	return errorKindNames[ErrGeneric]
+}
+
+type FastPushError struct {
+	Kind    ErrorKind
+	Message string
+	Err     error
+}
+
+func (e *FastPushError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %s", e.Message, e.Err.Error())
+	}
+	return e.Message
+}
+
+func NewError(kind ErrorKind, err error, format string, args ...interface{}) *FastPushError {
+	return &FastPushError{Kind: kind, Message: fmt.Sprintf(format, args...), Err: err}
+}
+
+// ExitCode returns the exit code matching err, plain errors exit with ErrGeneric
+func ExitCode(err error) int {
+	if e, ok := err.(*FastPushError); ok {
+		return int(e.Kind)
+	}
+	return int(ErrGeneric)
+}
+
+// checkResponse turns the outcome of a controller request into a FastPushError
+func checkResponse(response gorequest.Response, errs []error, action string) error {
+	if len(errs) > 0 && isCertificateError(errs[0]) {
+		return NewError(ErrCertificate, errs[0], "Could not verify the certificate of the fast-push controller while %s. Use --ca-cert to trust its CA or log in with cf login --skip-ssl-validation", action)
+	}
+	if len(errs) > 0 {
+		return NewError(ErrControllerUnreachable, errs[0], "Could not reach the fast-push controller while %s", action)
+	}
+	switch response.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return NewError(ErrAuthRejected, nil, "The fast-push controller rejected our credentials while %s", action)
+	default:
+		return NewError(ErrUnexpectedStatus, nil, "Unexpected status code %d received while %s", response.StatusCode, action)
+	}
+}
+
+// exit is replaced by the tests to observe the exit code
+var exit = os.Exit
+
+/*
+*	exitWithError prints the failure the way ui.Failed does but exits with the
+*	code of the error kind, ui.Failed itself always exits with 1.
+ */
+func (c *FastPushPlugin) exitWithError(err error) {
+	c.report.Finish(err)
+	c.ui.Say(terminal.FailureColor("FAILED"))
+	c.ui.Say("%s", err.Error())
+	exit(ExitCode(err))
+}
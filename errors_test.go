@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"code.cloudfoundry.org/cli/cf/terminal"
+)
+
+type recordingUI struct {
+	terminal.UI
+	said   []string
+	failed bool
+}
+
+func (u *recordingUI) Say(message string, args ...interface{}) {
+	u.said = append(u.said, message)
+}
+
+func (u *recordingUI) Failed(message string, args ...interface{}) {
+	u.failed = true
+}
+
+func TestExitWithErrorUsesKindAsExitCode(t *testing.T) {
+	defer func(original func(int)) { exit = original }(exit)
+	for kind := range errorKindNames {
+		code := -1
+		exit = func(c int) { code = c }
+		ui := &recordingUI{}
+		plugin := &FastPushPlugin{ui: ui}
+		plugin.exitWithError(NewError(kind, nil, "boom"))
+		if code != int(kind) {
+			t.Errorf("%s exited with %d, want %d", kind, code, kind)
+		}
+		if ui.failed {
+			t.Errorf("%s: ui.Failed exits with 1 and must not be used", kind)
+		}
+		if len(ui.said) == 0 || ui.said[0] != terminal.FailureColor("FAILED") {
+			t.Errorf("%s: FAILED not printed first: %q", kind, ui.said)
+		}
+	}
+}
+
+func TestExitCodeOfPlainError(t *testing.T) {
+	if code := ExitCode(errors.New("boom")); code != int(ErrGeneric) {
+		t.Errorf("plain error exits with %d, want %d", code, ErrGeneric)
+	}
+}
@@ -0,0 +1,447 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"code.cloudfoundry.org/cli/cf/formatters"
+)
+
+// Default of history_limit_mb in the project configuration
+const defaultHistoryLimitMB = 100
+
+// Kinds of history records
+const (
+	recordPush     = "push"
+	recordRollback = "rollback"
+)
+
+/*
+*	HistoryFile is the remote state of a file before a push: its content,
+*	stored once per checksum in the objects directory, or its link target.
+*	Absent files did not exist, rolling back deletes them.
+ */
+type HistoryFile struct {
+	Object string      `json:",omitempty"`
+	Mode   os.FileMode `json:",omitempty"`
+	Link   string      `json:",omitempty"`
+	Size   int64       `json:",omitempty"`
+	Absent bool        `json:",omitempty"`
+}
+
+type PushRecord struct {
+	ID       int
+	Time     time.Time
+	Kind     string
+	New      int
+	Modified int
+	Deleted  int
+	Files    map[string]*HistoryFile
+	// A record missing some previous contents cannot be rolled back, it is not stored
+	broken bool
+}
+
+/*
+*	History keeps the previous remote contents of the files overwritten or
+*	deleted by every push of an app, in the history directory of its state. The
+*	oldest records are dropped once their contents take more than limit bytes.
+ */
+type History struct {
+	dir    string
+	limit  int64
+	NextID int
+	Pushes []*PushRecord
+}
+
+func LoadHistory(appGuid string, limit int64) (*History, error) {
+	h := &History{dir: filepath.Join(appStateDir(appGuid), "history"), limit: limit, NextID: 1}
+	data, err := ioutil.ReadFile(filepath.Join(h.dir, "log.json"))
+	if os.IsNotExist(err) {
+		return h, nil
+	}
+	if err != nil {
+		return nil, NewError(ErrGeneric, err, "Could not read the push history")
+	}
+	if err := json.Unmarshal(data, h); err != nil {
+		return nil, NewError(ErrGeneric, err, "Could not parse the push history in %s", h.dir)
+	}
+	return h, nil
+}
+
+// Begin starts a record, it is only stored by Add once the push went through
+func (h *History) Begin(kind string) *PushRecord {
+	return &PushRecord{Kind: kind, Time: time.Now(), Files: map[string]*HistoryFile{}}
+}
+
+/*
+*	Capture stores the remote state of the paths the plan changes, unless a
+*	previous instance of the same push already did. Contents are streamed from
+*	the controller. When that fails, or the contents take more than the limit,
+*	the record is marked broken, the push could not be undone.
+ */
+func (h *History) Capture(client *ControllerClient, record *PushRecord, remote map[string]*FileEntry, plan *PushPlan) error {
+	// The other instances of a push that cannot be recorded download nothing
+	if record.broken {
+		return nil
+	}
+	for _, group := range []struct {
+		paths []string
+		count *int
+	}{{plan.New, &record.New}, {plan.Modified, &record.Modified}, {plan.Deleted, &record.Deleted}} {
+		for _, path := range group.paths {
+			if record.Files[path] != nil {
+				continue
+			}
+			f, err := h.capture(client, path, remote[path], h.limit-record.savedBytes())
+			if err != nil {
+				record.broken = true
+				return err
+			}
+			record.Files[path] = f
+			*group.count++
+		}
+	}
+	return nil
+}
+
+func (h *History) capture(client *ControllerClient, path string, entry *FileEntry, limit int64) (*HistoryFile, error) {
+	if entry == nil {
+		return &HistoryFile{Absent: true}, nil
+	}
+	if entry.Link != "" {
+		return &HistoryFile{Link: entry.Link}, nil
+	}
+	content, err := client.OpenFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer content.Close()
+	object, size, err := h.storeObject(content, limit)
+	if err == errHistoryLimit {
+		return nil, NewError(ErrGeneric, nil, "the previous versions of this push take more than history_limit_mb")
+	}
+	if err != nil {
+		return nil, NewError(ErrGeneric, err, "Could not store the previous version of %s", path)
+	}
+	return &HistoryFile{Object: object, Mode: entry.Mode, Size: size}, nil
+}
+
+var errHistoryLimit = errors.New("history limit exceeded")
+
+/*
+*	storeObject streams content into the objects directory while hashing it,
+*	the object is named after its checksum once complete. Content larger than
+*	limit is not stored.
+ */
+func (h *History) storeObject(content io.Reader, limit int64) (string, int64, error) {
+	dir := filepath.Join(h.dir, "objects")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", 0, err
+	}
+	tmp, err := ioutil.TempFile(dir, ".incoming-")
+	if err != nil {
+		return "", 0, err
+	}
+	// Nothing left to remove once it was renamed
+	defer os.Remove(tmp.Name())
+	hash := md5.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), io.LimitReader(content, limit+1))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", 0, err
+	}
+	if size > limit {
+		return "", 0, errHistoryLimit
+	}
+	object := hex.EncodeToString(hash.Sum(nil))
+	file := filepath.Join(dir, object)
+	if _, err := os.Stat(file); err == nil {
+		return object, size, nil
+	}
+	return object, size, os.Rename(tmp.Name(), file)
+}
+
+func (h *History) Object(object string) ([]byte, error) {
+	return ioutil.ReadFile(filepath.Join(h.dir, "objects", object))
+}
+
+// savedBytes is the size of the distinct previous contents kept by the record
+func (r *PushRecord) savedBytes() int64 {
+	size := int64(0)
+	counted := map[string]bool{}
+	for _, f := range r.Files {
+		if f.Object != "" && !counted[f.Object] {
+			counted[f.Object] = true
+			size += f.Size
+		}
+	}
+	return size
+}
+
+// Add stores a finished record and drops what no longer fits
+func (h *History) Add(record *PushRecord) error {
+	if len(record.Files) == 0 || record.broken {
+		return nil
+	}
+	record.ID = h.NextID
+	h.NextID++
+	h.Pushes = append(h.Pushes, record)
+	h.prune()
+	data, err := json.MarshalIndent(h, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(h.dir, 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(h.dir, "log.json"), data, 0644)
+}
+
+// prune keeps the newest records whose objects fit in the limit, then removes
+// the objects no record refers to anymore
+func (h *History) prune() {
+	size := int64(0)
+	counted := map[string]bool{}
+	keep := len(h.Pushes)
+	for i := len(h.Pushes) - 1; i >= 0; i-- {
+		for _, f := range h.Pushes[i].Files {
+			if f.Object != "" && !counted[f.Object] {
+				counted[f.Object] = true
+				size += f.Size
+			}
+		}
+		if size > h.limit {
+			break
+		}
+		keep = i
+	}
+	h.Pushes = h.Pushes[keep:]
+
+	used := map[string]bool{}
+	for _, record := range h.Pushes {
+		for _, f := range record.Files {
+			used[f.Object] = true
+		}
+	}
+	objects, _ := ioutil.ReadDir(filepath.Join(h.dir, "objects"))
+	for _, object := range objects {
+		if !used[object.Name()] {
+			os.Remove(filepath.Join(h.dir, "objects", object.Name()))
+		}
+	}
+}
+
+// Find returns the record with the given id, or the newest one for 0
+func (h *History) Find(id int) *PushRecord {
+	for i := len(h.Pushes) - 1; i >= 0; i-- {
+		if id == 0 || h.Pushes[i].ID == id {
+			return h.Pushes[i]
+		}
+	}
+	return nil
+}
+
+/*
+*	StateBefore returns the remote state of every file touched by the record
+*	with the given id or any later one, as it was before that push: the
+*	oldest capture of each path wins.
+ */
+func (h *History) StateBefore(id int) map[string]*HistoryFile {
+	state := map[string]*HistoryFile{}
+	for _, record := range h.Pushes {
+		if record.ID < id {
+			continue
+		}
+		for path, f := range record.Files {
+			if state[path] == nil {
+				state[path] = f
+			}
+		}
+	}
+	return state
+}
+
+/*
+*	beginHistory prepares the record of a push, nil when no history is kept.
+*	Problems with the history only cost the ability to roll back, they never
+*	stop a push.
+ */
+func (c *FastPushPlugin) beginHistory(client *ControllerClient, kind string, opts FastPushOptions) (*History, *PushRecord) {
+	if opts.DryRun || c.historyLimit <= 0 {
+		return nil, nil
+	}
+	history, err := LoadHistory(client.AppGuid, c.historyLimit)
+	if err != nil {
+		c.ui.Warn("warning: %s, this push will not be recorded", err.Error())
+		return nil, nil
+	}
+	return history, history.Begin(kind)
+}
+
+func (c *FastPushPlugin) finishHistory(history *History, record *PushRecord) {
+	if history == nil || record == nil {
+		return
+	}
+	if err := history.Add(record); err != nil {
+		c.ui.Warn("warning: Could not save the push history: %s", err.Error())
+		return
+	}
+	if record.ID == 0 {
+		return
+	}
+	c.ui.Say("Recorded as push %d, undo with cf fast-push-rollback", record.ID)
+}
+
+// ShowLog lists the recorded pushes of an app, newest first
+func (c *FastPushPlugin) ShowLog(client *ControllerClient) error {
+	history, err := LoadHistory(client.AppGuid, c.historyLimit)
+	if err != nil {
+		return err
+	}
+	if len(history.Pushes) == 0 {
+		c.ui.Say("No pushes recorded for this app")
+		return nil
+	}
+	table := c.ui.Table([]string{"id", "time", "kind", "new", "modified", "deleted", "saved"})
+	for i := len(history.Pushes) - 1; i >= 0; i-- {
+		record := history.Pushes[i]
+		saved := int64(0)
+		for _, f := range record.Files {
+			saved += f.Size
+		}
+		table.Add(strconv.Itoa(record.ID), record.Time.Local().Format("2006-01-02 15:04:05"), record.Kind,
+			strconv.Itoa(record.New), strconv.Itoa(record.Modified), strconv.Itoa(record.Deleted),
+			formatters.ByteSize(saved))
+	}
+	table.Print()
+	return nil
+}
+
+/*
+*	Rollback restores the remote files to their state before push id, undoing
+*	that push and every later one. The rollback is pushed to every instance
+*	and recorded itself, so it can be undone as well.
+ */
+func (c *FastPushPlugin) Rollback(client *ControllerClient, appName string, id int, opts FastPushOptions) error {
+	history, err := LoadHistory(client.AppGuid, c.historyLimit)
+	if err != nil {
+		return err
+	}
+	target := history.Find(id)
+	if target == nil {
+		if id == 0 {
+			return NewError(ErrGeneric, nil, "No pushes recorded for this app")
+		}
+		return NewError(ErrGeneric, nil, "Push %d is not in the history, see cf fast-push-log", id)
+	}
+	state := history.StateBefore(target.ID)
+	c.ui.Say("Rolling back to the state before push %d (%s)", target.ID, target.Time.Local().Format("2006-01-02 15:04:05"))
+
+	files := map[string]*FileEntry{}
+	plan := &PushPlan{Sizes: map[string]int64{}}
+	paths := []string{}
+	for path := range state {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		f := state[path]
+		if f.Absent {
+			c.ui.Say("[DEL] " + path)
+			plan.Deleted = append(plan.Deleted, path)
+			continue
+		}
+		entry := &FileEntry{Mode: f.Mode, Link: f.Link}
+		if f.Link != "" {
+			entry.Checksum = linkChecksum(f.Link)
+		} else {
+			content, err := history.Object(f.Object)
+			if err != nil {
+				return NewError(ErrGeneric, err, "The saved version of %s is missing", path)
+			}
+			entry.Checksum, entry.Content = f.Object, content
+		}
+		c.ui.Say("[MOD] " + path)
+		plan.Modified = append(plan.Modified, path)
+		plan.Sizes[path] = int64(len(entry.Content))
+		plan.Bytes += int64(len(entry.Content))
+		files[path] = entry
+	}
+	plan.Restart, plan.RestartReason = opts.Restart.Decide(plan)
+	if opts.DryRun {
+		c.ShowPlan(appName, plan)
+		return nil
+	}
+
+	record := history.Begin(recordRollback)
+	// Saved once for all instances, like a push does
+	baseline := LoadBaseline(client.AppGuid)
+	next := baseline.Copy()
+	instances := client.InstanceClients()
+	var first error
+	failed := 0
+	for _, instance := range instances {
+		if err := c.restoreInstance(instance, history, record, next, files, plan); err != nil {
+			c.ui.Warn("warning: instance %s: %s", instanceName(instance), err.Error())
+			if first == nil {
+				first = err
+			}
+			failed++
+		}
+	}
+	// Without a baseline nothing is known about the other files, recording
+	// only the restored ones would make all others look changed remotely
+	if failed < len(instances) && baseline.known {
+		if failed > 0 {
+			next.KeepStale(baseline)
+		}
+		next.Save()
+	}
+	c.finishHistory(history, record)
+	if failed > 0 {
+		return NewError(ErrorKind(ExitCode(first)), first, "Rollback failed on %d instance(s)", failed)
+	}
+	return nil
+}
+
+func (c *FastPushPlugin) restoreInstance(client *ControllerClient, history *History, record *PushRecord, baseline *Baseline, files map[string]*FileEntry, plan *PushPlan) error {
+	remote, err := client.ListFiles()
+	if err != nil {
+		return err
+	}
+	if err := history.Capture(client, record, remote, plan); err != nil {
+		return err
+	}
+	if len(plan.Deleted) > 0 {
+		payload, _ := json.Marshal(plan.Deleted)
+		response, _, errs := client.Delete("/files").Send(string(payload)).End()
+		if err := checkResponse(response, errs, "deleting files"); err != nil {
+			return err
+		}
+	}
+	status, err := c.UploadFiles(client, files, plan.Restart)
+	if err != nil {
+		return err
+	}
+
+	for _, path := range plan.Deleted {
+		baseline.Record(path, nil)
+	}
+	for path, entry := range files {
+		baseline.Record(path, entry)
+	}
+	c.ui.Say("Restart: %s (%s)", plan.Restart, plan.RestartReason)
+	c.ui.Say(status.Health)
+	return nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func md5Hex(content string) string {
+	sum := md5.Sum([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// addPush records a push that replaced the given previous contents, "" for
+// files that did not exist before
+func addPush(t *testing.T, h *History, files map[string]string) *PushRecord {
+	record := h.Begin(recordPush)
+	for path, content := range files {
+		if content == "" {
+			record.Files[path] = &HistoryFile{Absent: true}
+			continue
+		}
+		object, _, err := h.storeObject(strings.NewReader(content), int64(len(content)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		record.Files[path] = &HistoryFile{Object: object, Size: int64(len(content))}
+	}
+	if err := h.Add(record); err != nil {
+		t.Fatal(err)
+	}
+	return record
+}
+
+func TestHistoryPrune(t *testing.T) {
+	inTempDir(t, func() {
+		h, _ := LoadHistory("guid", 10)
+		addPush(t, h, map[string]string{"a": "aaaa", "b": ""})
+		addPush(t, h, map[string]string{"a": "bbbb"})
+		addPush(t, h, map[string]string{"c": "cccccc"})
+
+		loaded, err := LoadHistory("guid", 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(loaded.Pushes) != 2 || loaded.Pushes[0].ID != 2 || loaded.NextID != 4 {
+			t.Fatalf("kept pushes %+v, next id %d; want 2 and 3, next id 4", loaded.Pushes, loaded.NextID)
+		}
+		if _, err := loaded.Object(md5Hex("aaaa")); err == nil {
+			t.Error("the object of the dropped push was not removed")
+		}
+
+		// A push that alone exceeds the limit is dropped right away
+		big := addPush(t, loaded, map[string]string{"d": "0123456789abc"})
+		if loaded.Find(big.ID) != nil {
+			t.Error("a push larger than the limit was kept")
+		}
+	})
+}
+
+func TestHistoryStateBefore(t *testing.T) {
+	inTempDir(t, func() {
+		h, _ := LoadHistory("guid", 1024)
+		addPush(t, h, map[string]string{"a": "a0", "new": ""})
+		addPush(t, h, map[string]string{"a": "a1", "b": "b1"})
+		addPush(t, h, map[string]string{"b": "b2"})
+
+		tests := []struct {
+			id   int
+			want map[string]string
+		}{
+			{1, map[string]string{"a": "a0", "new": "", "b": "b1"}},
+			{2, map[string]string{"a": "a1", "b": "b1"}},
+			{3, map[string]string{"b": "b2"}},
+		}
+		for _, test := range tests {
+			state := h.StateBefore(test.id)
+			if len(state) != len(test.want) {
+				t.Errorf("StateBefore(%d) has %d files, want %d", test.id, len(state), len(test.want))
+			}
+			for path, content := range test.want {
+				f := state[path]
+				if f == nil {
+					t.Errorf("StateBefore(%d) misses %s", test.id, path)
+					continue
+				}
+				if content == "" {
+					if !f.Absent {
+						t.Errorf("StateBefore(%d): %s should not exist", test.id, path)
+					}
+					continue
+				}
+				if f.Object != md5Hex(content) {
+					t.Errorf("StateBefore(%d): %s is %s, want the object of %q", test.id, path, f.Object, content)
+				}
+			}
+		}
+	})
+}
+
+func TestHistoryCapture(t *testing.T) {
+	contents := map[string]string{"a.txt": "aaaaaa", "b.txt": "bbbbbb"}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(contents[strings.TrimPrefix(r.URL.Path, "/files/")]))
+	}))
+	defer server.Close()
+	client := &ControllerClient{Endpoint: server.URL, Instance: anyInstance, Credentials: Credentials{Header: "x-auth-token", Value: "secret"}}
+	remote := map[string]*FileEntry{"a.txt": {Checksum: md5Hex("aaaaaa")}, "b.txt": {Checksum: md5Hex("bbbbbb")}}
+	plan := &PushPlan{New: []string{"c.txt"}, Modified: []string{"a.txt"}, Deleted: []string{"b.txt"}}
+
+	inTempDir(t, func() {
+		h, _ := LoadHistory("guid", 12)
+		record := h.Begin(recordPush)
+		if err := h.Capture(client, record, remote, plan); err != nil {
+			t.Fatal(err)
+		}
+		if !record.Files["c.txt"].Absent || record.New != 1 || record.Modified != 1 || record.Deleted != 1 {
+			t.Errorf("got record %+v", record)
+		}
+		for path, content := range contents {
+			f := record.Files[path]
+			if f.Object != md5Hex(content) || f.Size != int64(len(content)) {
+				t.Errorf("%s captured as %+v", path, f)
+			}
+			if stored, err := h.Object(f.Object); err != nil || string(stored) != content {
+				t.Errorf("%s stored as %q, %v", path, stored, err)
+			}
+		}
+	})
+
+	inTempDir(t, func() {
+		h, _ := LoadHistory("guid", 10)
+		record := h.Begin(recordPush)
+		if err := h.Capture(client, record, remote, plan); err == nil || !record.broken {
+			t.Fatalf("12 bytes captured with a limit of 10")
+		}
+		objects, _ := ioutil.ReadDir(filepath.Join(h.dir, "objects"))
+		if len(objects) != 1 {
+			t.Errorf("got %d objects, want only the one within the limit", len(objects))
+		}
+	})
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Files that cf push never uploads, .cfignore rules are added on top of them
+var defaultIgnorePatterns = []string{
+	".cfignore",
+	".fastpushignore",
+	"/manifest.yml",
+	".gitignore",
+	".git",
+	".hg",
+	".svn",
+	"_darcs",
+	".DS_Store",
+}
+
+// Ignore files read from the app root, later rules override earlier ones
+var ignoreFiles = []string{".cfignore", ".fastpushignore"}
+
+type ignoreRule struct {
+	pattern *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+/*
+*	IgnoreRules decides which paths take part in a fast-push. Patterns follow
+*	gitignore semantics: the last matching rule wins, "!" re-includes a path,
+*	a trailing "/" only matches directories, a pattern containing a "/" is
+*	anchored to the app root and "**" spans any number of directories.
+ */
+type IgnoreRules struct {
+	rules []ignoreRule
+}
+
+func LoadIgnoreRules(root string) (*IgnoreRules, error) {
+	r := &IgnoreRules{}
+	for _, pattern := range defaultIgnorePatterns {
+		r.Add(pattern)
+	}
+	for _, name := range ignoreFiles {
+		f, err := os.Open(filepath.Join(root, name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			r.Add(scanner.Text())
+		}
+		f.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// Add parses one line of an ignore file, blank lines and comments are skipped.
+// Like git, a pattern that cannot be parsed never matches.
+func (r *IgnoreRules) Add(line string) {
+	if rule, ok, err := parseIgnoreLine(line); ok && err == nil {
+		r.rules = append(r.rules, rule)
+	}
+}
+
+// parseIgnoreLine parses one line of an ignore file, ok is false for lines
+// without a pattern
+func parseIgnoreLine(line string) (ignoreRule, bool, error) {
+	line = strings.TrimRight(line, " \t\r")
+	rule := ignoreRule{}
+	if line == "" || strings.HasPrefix(line, "#") {
+		return rule, false, nil
+	}
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return rule, false, nil
+	}
+	pattern, err := compilePattern(line)
+	if err != nil {
+		return rule, true, err
+	}
+	rule.pattern = pattern
+	return rule, true, nil
+}
+
+// compilePattern turns a gitignore style pattern into a regexp for paths
+// relative to the app root. Without a "/" the pattern matches at any depth.
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	anchored := strings.Contains(pattern, "/")
+	expr := globToRegexp(strings.TrimPrefix(pattern, "/"))
+	if anchored {
+		return regexp.Compile("^" + expr + "$")
+	}
+	return regexp.Compile("(^|/)" + expr + "$")
+}
+
+// Ignored reports whether path, relative to the app root, is excluded. A path
+// inside an excluded directory is excluded as well, like git does.
+func (r *IgnoreRules) Ignored(path string, isDir bool) bool {
+	path = filepath.ToSlash(filepath.Clean(path))
+	if path == "." {
+		return false
+	}
+	parts := strings.Split(path, "/")
+	for i := 1; i < len(parts); i++ {
+		if r.match(strings.Join(parts[:i], "/"), true) {
+			return true
+		}
+	}
+	return r.match(path, isDir)
+}
+
+func (r *IgnoreRules) match(path string, isDir bool) bool {
+	ignored := false
+	for _, rule := range r.rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		if rule.pattern.MatchString(path) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+// Filter drops the ignored files from a file listing
+func (r *IgnoreRules) Filter(files map[string]*FileEntry) map[string]*FileEntry {
+	filtered := map[string]*FileEntry{}
+	for path, f := range files {
+		if !r.Ignored(path, false) {
+			filtered[path] = f
+		}
+	}
+	return filtered
+}
+
+// LocalFiles lists the files taking part in a fast-push. The rules are returned
+// as well so remote listings can be filtered the same way. Checksums come from
+// the FileIndex unless opts.Rehash is set.
+func LocalFiles(opts ScanOptions) (map[string]*FileEntry, *IgnoreRules, error) {
+	ignore, err := LoadIgnoreRules(".")
+	if err != nil {
+		return nil, nil, err
+	}
+	index := LoadFileIndex()
+	files, err := index.Scan(ignore, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	index.Save()
+	return files, ignore, nil
+}
+
+/*
+*	localFiles lists the local files the way the controller can store them.
+*	Links are followed and modes left unknown when it does not support them,
+*	otherwise every push would find them changed again.
+ */
+func (c *FastPushPlugin) localFiles(client *ControllerClient, rehash bool) (map[string]*FileEntry, *IgnoreRules, error) {
+	capabilities := client.Capabilities()
+	opts := c.scan
+	opts.Rehash = rehash
+	opts.FollowLinks = !capabilities.supports(featureSymlinks)
+	files, ignore, err := LocalFiles(opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !capabilities.supports(featureModes) {
+		for _, f := range files {
+			f.Mode = 0
+		}
+	}
+	return files, ignore, nil
+}
+
+func globToRegexp(glob string) string {
+	var expr bytes.Buffer
+	for i := 0; i < len(glob); i++ {
+		ch := glob[i]
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			expr.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "/**") && i+3 == len(glob):
+			expr.WriteString("/.*")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			expr.WriteString(".*")
+			i++
+		case ch == '*':
+			expr.WriteString("[^/]*")
+		case ch == '?':
+			expr.WriteString("[^/]")
+		case ch == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				expr.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			expr.WriteString("[" + class + "]")
+			i += end + 1
+		case ch == '\\' && i+1 < len(glob):
+			i++
+			expr.WriteString(regexp.QuoteMeta(string(glob[i])))
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(ch)))
+		}
+	}
+	return expr.String()
+}
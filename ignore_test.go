@@ -0,0 +1,76 @@
+package main
+
+import "testing"
+
+func TestIgnored(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules []string
+		path  string
+		isDir bool
+		want  bool
+	}{
+		{"plain name at any depth", []string{"*.log"}, "logs/app.log", false, true},
+		{"plain name at the root", []string{"*.log"}, "app.log", false, true},
+		{"no match", []string{"*.log"}, "app.txt", false, false},
+		{"star does not cross directories", []string{"src/*.js"}, "src/lib/a.js", false, false},
+		{"anchored with a leading slash", []string{"/build"}, "build", true, true},
+		{"anchored pattern not matched deeper", []string{"/build"}, "web/build", true, false},
+		{"slash in the middle anchors", []string{"web/dist"}, "app/web/dist", true, false},
+		{"unanchored directory at any depth", []string{"node_modules"}, "web/node_modules", true, true},
+		{"file inside an ignored directory", []string{"node_modules"}, "web/node_modules/x/index.js", false, true},
+		{"dir-only rule skips files", []string{"tmp/"}, "tmp", false, false},
+		{"dir-only rule matches directories", []string{"tmp/"}, "tmp", true, true},
+		{"dir-only rule covers the contents", []string{"tmp/"}, "tmp/a.txt", false, true},
+		{"leading double star", []string{"**/cache"}, "a/b/cache", true, true},
+		{"leading double star at the root", []string{"**/cache"}, "cache", true, true},
+		{"trailing double star", []string{"logs/**"}, "logs/2020/a.log", false, true},
+		{"trailing double star not the directory itself", []string{"logs/**"}, "logs", true, false},
+		{"inner double star", []string{"a/**/b"}, "a/x/y/b", false, true},
+		{"inner double star without directories", []string{"a/**/b"}, "a/b", false, true},
+		{"negation re-includes", []string{"*.log", "!keep.log"}, "keep.log", false, false},
+		{"last rule wins", []string{"!keep.log", "*.log"}, "keep.log", false, true},
+		{"negation cannot re-include below an ignored directory", []string{"logs/", "!logs/keep.log"}, "logs/keep.log", false, true},
+		{"character class", []string{"file[0-9].txt"}, "file7.txt", false, true},
+		{"negated character class", []string{"file[!0-9].txt"}, "file7.txt", false, false},
+		{"question mark", []string{"?.txt"}, "a.txt", false, true},
+		{"escaped bang is literal", []string{`\!important`}, "!important", false, true},
+		{"comments are skipped", []string{"# *.txt"}, "a.txt", false, false},
+		{"defaults ignore .git", nil, ".git/config", false, true},
+		{"defaults ignore the manifest at the root only", nil, "config/manifest.yml", false, false},
+	}
+	for _, test := range tests {
+		r := &IgnoreRules{}
+		for _, pattern := range defaultIgnorePatterns {
+			r.Add(pattern)
+		}
+		for _, rule := range test.rules {
+			r.Add(rule)
+		}
+		if got := r.Ignored(test.path, test.isDir); got != test.want {
+			t.Errorf("%s: Ignored(%q, %v) with %q = %v, want %v", test.name, test.path, test.isDir, test.rules, got, test.want)
+		}
+	}
+}
+
+func TestGlobToRegexp(t *testing.T) {
+	tests := []struct {
+		glob string
+		want string
+	}{
+		{"*.go", `[^/]*\.go`},
+		{"a?c", `a[^/]c`},
+		{"**/x", `(.*/)?x`},
+		{"x/**", `x/.*`},
+		{"a/**/b", `a/(.*/)?b`},
+		{"[abc]", `[abc]`},
+		{"[!abc]", `[^abc]`},
+		{"[unclosed", `\[unclosed`},
+		{`\*`, `\*`},
+	}
+	for _, test := range tests {
+		if got := globToRegexp(test.glob); got != test.want {
+			t.Errorf("globToRegexp(%q) = %q, want %q", test.glob, got, test.want)
+		}
+	}
+}
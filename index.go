@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bufio"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const indexVersion = "fastpush-index 1 md5"
+
+// indexFile is the checksum cache of the app root in the working directory
+func indexFile() string {
+	return filepath.Join(rootStateDir(), "index")
+}
+
+type indexEntry struct {
+	size     int64
+	mtime    int64
+	inode    uint64
+	checksum string
+}
+
+/*
+*	FileIndex caches the checksums of the local files. An entry is reused as
+*	long as size, mtime and inode of the file are unchanged. Like git, entries
+*	modified in the same second the index was written are not trusted since a
+*	later write in that second would not change the mtime.
+ */
+type FileIndex struct {
+	written int64
+	entries map[string]*indexEntry
+}
+
+// LoadFileIndex reads the cache, a missing or unreadable cache is an empty one
+func LoadFileIndex() *FileIndex {
+	index := &FileIndex{entries: map[string]*indexEntry{}}
+	f, err := os.Open(indexFile())
+	if err != nil {
+		return index
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	if !scanner.Scan() || scanner.Text() != indexVersion {
+		return index
+	}
+	if !scanner.Scan() {
+		return index
+	}
+	index.written, _ = strconv.ParseInt(scanner.Text(), 10, 64)
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), "\t", 5)
+		if len(fields) != 5 {
+			continue
+		}
+		path, err := strconv.Unquote(fields[4])
+		if err != nil {
+			continue
+		}
+		entry := &indexEntry{checksum: fields[0]}
+		entry.size, _ = strconv.ParseInt(fields[1], 10, 64)
+		entry.mtime, _ = strconv.ParseInt(fields[2], 10, 64)
+		entry.inode, _ = strconv.ParseUint(fields[3], 10, 64)
+		index.entries[path] = entry
+	}
+	return index
+}
+
+// Save writes the cache. It is only a cache, so failing to write it does not
+// fail the push.
+func (index *FileIndex) Save() {
+	file := indexFile()
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return
+	}
+	tmp := file + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return
+	}
+	w := bufio.NewWriter(f)
+	fmt.Fprintln(w, indexVersion)
+	fmt.Fprintln(w, time.Now().UnixNano())
+	for path, entry := range index.entries {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%s\n", entry.checksum, entry.size, entry.mtime, entry.inode, strconv.Quote(path))
+	}
+	if w.Flush() != nil || f.Close() != nil {
+		os.Remove(tmp)
+		return
+	}
+	os.Rename(tmp, file)
+}
+
+// lookup returns the cached checksum of a file when its metadata is unchanged
+func (index *FileIndex) lookup(path string, info os.FileInfo) (string, bool) {
+	entry := index.entries[path]
+	if entry == nil {
+		return "", false
+	}
+	mtime := info.ModTime().UnixNano()
+	if entry.size != info.Size() || entry.mtime != mtime || entry.inode != fileInode(info) {
+		return "", false
+	}
+	if mtime >= index.written-int64(time.Second) {
+		return "", false
+	}
+	return entry.checksum, true
+}
+
+/*
+*	Scan lists the files below the working directory that are not ignored,
+*	with their checksums and modes. Ignored directories are not entered at
+*	all, symlinks are handled by scanSymlink and other special files are
+*	skipped. Files missing from the cache, or every file with Rehash, are
+*	hashed in parallel. The index is updated to exactly the hashed files.
+ */
+func (index *FileIndex) Scan(ignore *IgnoreRules, opts ScanOptions) (map[string]*FileEntry, error) {
+	files := map[string]*FileEntry{}
+	entries := map[string]*indexEntry{}
+	stale := []string{}
+	infos := map[string]os.FileInfo{}
+	err := filepath.Walk(".", func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == "." {
+			return nil
+		}
+		if info.IsDir() {
+			if ignore.Ignored(path, true) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if ignore.Ignored(path, false) {
+			return nil
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			entry, follow, err := scanSymlink(path, opts)
+			if err != nil || !follow {
+				if entry != nil {
+					files[path] = entry
+				}
+				return err
+			}
+			if info, err = os.Stat(path); err != nil {
+				return err
+			}
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		if checksum, ok := index.lookup(path, info); ok && !opts.Rehash {
+			files[path] = &FileEntry{Checksum: checksum, Mode: fileMode(info)}
+			entries[path] = index.entries[path]
+			return nil
+		}
+		stale = append(stale, path)
+		infos[path] = info
+		return nil
+	})
+	if e, ok := err.(*FastPushError); ok {
+		return nil, e
+	}
+	if err != nil {
+		return nil, NewError(ErrGeneric, err, "Could not list the local files")
+	}
+
+	checksums, err := hashFiles(stale)
+	if err != nil {
+		return nil, err
+	}
+	for i, path := range stale {
+		info := infos[path]
+		files[path] = &FileEntry{Checksum: checksums[i], Mode: fileMode(info)}
+		entries[path] = &indexEntry{
+			size:     info.Size(),
+			mtime:    info.ModTime().UnixNano(),
+			inode:    fileInode(info),
+			checksum: checksums[i],
+		}
+	}
+	index.entries = entries
+	return files, nil
+}
+
+// hashFiles computes the checksums of paths with one worker per CPU
+func hashFiles(paths []string) ([]string, error) {
+	checksums := make([]string, len(paths))
+	errs := make([]error, len(paths))
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < runtime.NumCPU(); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				checksums[i], errs[i] = fileChecksum(paths[i])
+			}
+		}()
+	}
+	for i := range paths {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			return nil, NewError(ErrGeneric, err, "Could not read %s", paths[i])
+		}
+	}
+	return checksums, nil
+}
+
+// fileChecksum must match the checksums the controller lists for its files
+func fileChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := md5.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// inTempDir runs f in a fresh working directory, with the state of the plugin
+// in a fresh CF home outside of it
+func inTempDir(t *testing.T, f func()) {
+	dir, err := ioutil.TempDir("", "fastpush")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	home, err := ioutil.TempDir("", "fastpush-home")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(home)
+	defer os.Setenv("CF_HOME", os.Getenv("CF_HOME"))
+	os.Setenv("CF_HOME", home)
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	f()
+}
+
+func TestFileIndexRoundTrip(t *testing.T) {
+	inTempDir(t, func() {
+		index := &FileIndex{entries: map[string]*indexEntry{
+			"plain.txt":           {size: 3, mtime: 100, inode: 7, checksum: "abc"},
+			"with\ttab\nand line": {size: 1, mtime: 2, inode: 3, checksum: "def"},
+		}}
+		index.Save()
+		loaded := LoadFileIndex()
+		if len(loaded.entries) != 2 {
+			t.Fatalf("loaded %d entries, want 2", len(loaded.entries))
+		}
+		for path, want := range index.entries {
+			if got := loaded.entries[path]; got == nil || *got != *want {
+				t.Errorf("entry %q = %+v, want %+v", path, got, want)
+			}
+		}
+		if loaded.written == 0 {
+			t.Error("the write time was not stored")
+		}
+	})
+}
+
+func TestFileIndexInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"other version", "fastpush-index 0 sha1\n0\nabc\t1\t2\t3\t\"a\"\n"},
+		{"missing write time", indexVersion + "\n"},
+		{"malformed lines", indexVersion + "\n0\nabc\t1\n"},
+	}
+	for _, test := range tests {
+		inTempDir(t, func() {
+			os.MkdirAll(filepath.Dir(indexFile()), 0755)
+			ioutil.WriteFile(indexFile(), []byte(test.content), 0644)
+			if n := len(LoadFileIndex().entries); n != 0 {
+				t.Errorf("%s: loaded %d entries, want none", test.name, n)
+			}
+		})
+	}
+}
+
+func TestFileIndexLookup(t *testing.T) {
+	inTempDir(t, func() {
+		ioutil.WriteFile("a.txt", []byte("abc"), 0644)
+		old := time.Now().Add(-time.Hour)
+		os.Chtimes("a.txt", old, old)
+		info, _ := os.Stat("a.txt")
+		entry := &indexEntry{size: info.Size(), mtime: info.ModTime().UnixNano(), inode: fileInode(info), checksum: "sum"}
+
+		tests := []struct {
+			name    string
+			entry   indexEntry
+			written int64
+			ok      bool
+		}{
+			{"unchanged", *entry, time.Now().UnixNano(), true},
+			{"other size", indexEntry{size: 4, mtime: entry.mtime, inode: entry.inode}, time.Now().UnixNano(), false},
+			{"other mtime", indexEntry{size: entry.size, mtime: entry.mtime + 1, inode: entry.inode}, time.Now().UnixNano(), false},
+			{"modified while the index was written", *entry, entry.mtime, false},
+		}
+		for _, test := range tests {
+			e := test.entry
+			index := &FileIndex{written: test.written, entries: map[string]*indexEntry{"a.txt": &e}}
+			if _, ok := index.lookup("a.txt", info); ok != test.ok {
+				t.Errorf("%s: lookup = %v, want %v", test.name, ok, test.ok)
+			}
+		}
+	})
+}
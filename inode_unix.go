@@ -0,0 +1,22 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileInode tells a file replaced by another one with the same size and mtime apart
+func fileInode(info os.FileInfo) uint64 {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(stat.Ino)
+	}
+	return 0
+}
+
+// fileMode is the permission part of the mode that is pushed and compared
+func fileMode(info os.FileInfo) os.FileMode {
+	return info.Mode().Perm()
+}
@@ -0,0 +1,19 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"os"
+)
+
+// Windows has no inodes in os.FileInfo, size and mtime have to do
+func fileInode(info os.FileInfo) uint64 {
+	return 0
+}
+
+// Windows has no executable bit, every file looks like 0666. The mode is left
+// unknown so the one in the container is neither compared nor replaced.
+func fileMode(info os.FileInfo) os.FileMode {
+	return 0
+}
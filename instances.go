@@ -0,0 +1,117 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"code.cloudfoundry.org/cli/plugin/models"
+)
+
+// The gorouter sends requests carrying this header to the given app instance
+const instanceHeader = "X-CF-APP-INSTANCE"
+
+const anyInstance = -1
+
+func runningInstances(app plugin_models.GetAppModel) []int {
+	indexes := []int{}
+	for index, instance := range app.Instances {
+		if strings.EqualFold(instance.State, "running") {
+			indexes = append(indexes, index)
+		}
+	}
+	return indexes
+}
+
+// ForInstance returns a copy of the client pinned to one app instance
+func (cc *ControllerClient) ForInstance(index int) *ControllerClient {
+	pinned := *cc
+	pinned.Instance = index
+	return &pinned
+}
+
+// InstanceClients returns a client per running instance. When instances cannot
+// be addressed individually the client itself is the only target.
+func (cc *ControllerClient) InstanceClients() []*ControllerClient {
+	if len(cc.Instances) == 0 {
+		return []*ControllerClient{cc}
+	}
+	clients := []*ControllerClient{}
+	for _, index := range cc.Instances {
+		clients = append(clients, cc.ForInstance(index))
+	}
+	return clients
+}
+
+/*
+*	SyncInstances runs SyncFiles against every running instance, each instance
+*	is diffed on its own since they may have drifted apart. A failing instance
+*	does not stop the others, the results are summarized at the end. The
+*	result tells whether any instance was told to restart.
+ */
+func (c *FastPushPlugin) SyncInstances(client *ControllerClient, appName string, opts FastPushOptions, paths map[string]bool) (bool, error) {
+	// One record covers every instance, they all get the same push
+	history, record := c.beginHistory(client, recordPush, opts)
+	defer c.finishHistory(history, record)
+	opts.history, opts.record = history, record
+	// The baseline is kept per app, every instance is checked against the
+	// state of the previous push and the new one is saved once all of them
+	// were pushed. Otherwise the first instance would make the old files of
+	// the others look changed remotely.
+	opts.baseline = LoadBaseline(client.AppGuid)
+	opts.nextBaseline = opts.baseline.Copy()
+
+	targets := client.InstanceClients()
+	if len(targets) == 1 {
+		restart, err := c.SyncFiles(targets[0], appName, opts, paths)
+		if err == nil && !opts.DryRun {
+			opts.nextBaseline.Save()
+		}
+		return restart != restartNone, err
+	}
+
+	restarted := false
+	results := make([]error, len(targets))
+	for i, target := range targets {
+		c.ui.Say("")
+		c.ui.Say("Instance %d:", target.Instance)
+		var restart string
+		restart, results[i] = c.SyncFiles(target, appName, opts, paths)
+		if restart != restartNone {
+			restarted = true
+		}
+		event := &Event{Type: "instance", Instance: instanceRef(target), OK: boolValue(results[i] == nil)}
+		if results[i] != nil {
+			event.Error = errorReport(results[i])
+		}
+		c.report.Event(event)
+	}
+
+	c.ui.Say("")
+	table := c.ui.Table([]string{"instance", "result"})
+	failed := 0
+	kind := ErrGeneric
+	for i, target := range targets {
+		result := "ok"
+		if results[i] != nil {
+			// The exit code reflects the first failure
+			if failed == 0 {
+				kind = ErrorKind(ExitCode(results[i]))
+			}
+			result = results[i].Error()
+			failed++
+		}
+		table.Add(strconv.Itoa(target.Instance), result)
+	}
+	table.Print()
+	if failed < len(targets) && !opts.DryRun {
+		// The failed instances may still hold the previous files
+		if failed > 0 {
+			opts.nextBaseline.KeepStale(opts.baseline)
+		}
+		opts.nextBaseline.Save()
+	}
+	if failed > 0 {
+		return restarted, NewError(kind, nil, "fast-push failed on %d of %d instances", failed, len(targets))
+	}
+	return restarted, nil
+}
@@ -1,20 +1,19 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
-	"regexp"
-	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
-	"encoding/json"
-	"code.cloudfoundry.org/cli/plugin"
+	"code.cloudfoundry.org/cli/cf/formatters"
 	"code.cloudfoundry.org/cli/cf/terminal"
 	"code.cloudfoundry.org/cli/cf/trace"
-	"github.com/parnurzeal/gorequest"
+	"code.cloudfoundry.org/cli/plugin"
 	"github.com/simonleung8/flags"
-	"github.com/xiwenc/cf-fastpush-controller/lib"
-	"io/ioutil"
-	"strings"
 )
 
 /*
@@ -23,7 +22,51 @@ import (
 *
  */
 type FastPushPlugin struct {
-	ui terminal.UI
+	ui     terminal.UI
+	report *Reporter
+	paths  *PathMapper
+	scan   ScanOptions
+	// Bytes of previous remote contents kept for rollbacks, 0 keeps no history
+	historyLimit int64
+}
+
+/*
+*	PushPlan describes the change set a fast-push would apply to the container.
+*	It is printed as is for a dry run.
+ */
+type PushPlan struct {
+	New           []string
+	Modified      []string
+	Deleted       []string
+	Conflicts     []string
+	Bytes         int64
+	Sizes         map[string]int64
+	Restart       string
+	RestartReason string
+}
+
+func (p *PushPlan) Paths() []string {
+	paths := append(append(append([]string{}, p.New...), p.Modified...), p.Deleted...)
+	sort.Strings(paths)
+	return paths
+}
+
+type FastPushOptions struct {
+	DryRun     bool
+	NoDelete   bool
+	Watch      bool
+	Rehash     bool
+	Force      bool
+	Restart    RestartPolicy
+	Wait       WaitOptions
+	Controller ControllerOptions
+	// Set by SyncInstances, the previous remote contents are captured there
+	history *History
+	record  *PushRecord
+	// Set by SyncInstances: conflicts are found against baseline, the pushed
+	// files are recorded in nextBaseline
+	baseline     *Baseline
+	nextBaseline *Baseline
 }
 
 type VCAPApplication struct {
@@ -50,106 +93,431 @@ type VCAPApplication struct {
 func (c *FastPushPlugin) Run(cliConnection plugin.CliConnection, args []string) {
 	// Ensure that the user called the command fast-push
 	// alias fp is auto mapped
-	var dryRun bool
 	traceLogger := trace.NewLogger(os.Stdout, true, os.Getenv("CF_TRACE"), "")
 	c.ui = terminal.NewUI(os.Stdin, os.Stdout, terminal.NewTeePrinter(os.Stdout), traceLogger)
 
 	cliLogged, err := cliConnection.IsLoggedIn()
 	if err != nil {
-		c.ui.Failed(err.Error())
+		c.exitWithError(err)
 	}
 
 	if cliLogged == false {
-		panic("Cannot perform fast-push without being logged in to CF")
+		c.exitWithError(NewError(ErrNotLoggedIn, nil, "Cannot perform fast-push without being logged in to CF"))
+	}
+
+	config, err := LoadProjectConfig()
+	if err != nil {
+		c.exitWithError(err)
 	}
+	c.paths, err = NewPathMapper(config.PathMappings)
+	if err != nil {
+		c.exitWithError(err)
+	}
+	c.scan.ExternalSymlinks = config.ExternalSymlinks
+	c.historyLimit = int64(config.HistoryLimitMB) * 1024 * 1024
 
 	if args[0] == "fast-push" || args[0] == "fp" {
-		if len(args) == 1 {
-			c.showUsage(args)
-			return
-		}
 		// set flag for dry run
 		fc := flags.New()
 		fc.NewBoolFlag("dry", "d", "bool dry run flag")
+		fc.NewBoolFlag("no-delete", "", "keep remote files that no longer exist locally")
+		fc.NewBoolFlag("watch", "w", "keep running and push changes as files are saved")
+		fc.NewBoolFlag("restart", "", "always restart the app after the push")
+		fc.NewBoolFlag("no-restart", "", "never restart the app after the push")
+		fc.NewBoolFlag("wait", "", "wait until every instance is healthy after the push")
+		fc.NewStringFlag("timeout", "", "how long --wait waits, e.g. 90s or 5m (default 2m)")
+		fc.NewStringFlag("health-url", "", "URL or path of the app that must answer 2xx for --wait")
+		fc.NewBoolFlag("force", "", "overwrite files that were changed remotely since the last push")
+		fc.NewBoolFlag("rehash", "", "ignore the checksum cache and hash every local file")
+		fc.NewStringFlag("external-symlinks", "", "symlinks leaving the app root: follow, preserve, skip or error")
+		fc.NewStringFlag("manifest", "f", "path to the manifest (default ./manifest.yml)")
+		fc.NewStringFlag("app", "", "app of the manifest to push, all apps by default")
+		addOutputFlag(fc)
+		addPathFlag(fc)
+		addControllerFlags(fc)
 
-		err := fc.Parse(args[1:]...)
+		if err := fc.Parse(args[1:]...); err != nil {
+			c.exitWithError(err)
+		}
+		manifestPath := "."
+		if fc.IsSet("manifest") {
+			manifestPath = fc.String("manifest")
+		}
+		manifest, err := LoadManifest(manifestPath, fc.IsSet("manifest"))
+		if err != nil {
+			c.exitWithError(err)
+		}
+		appName := ""
+		if len(fc.Args()) > 0 {
+			appName = fc.Args()[0]
+		}
+		if appName == "" && !fc.IsSet("app") && len(manifest) == 0 {
+			c.showUsage(args)
+			return
+		}
+		apps, err := SelectApps(manifest, appName, fc.String("app"))
 		if err != nil {
-			c.ui.Failed(err.Error())
+			c.exitWithError(err)
+		}
+		if fc.IsSet("path") {
+			if len(apps) > 1 {
+				c.exitWithError(NewError(ErrGeneric, nil, "--path applies to a single app, choose one with --app"))
+			}
+			apps[0].Path = fc.String("path")
+		}
+		if len(apps) > 1 && fc.Bool("watch") {
+			c.exitWithError(NewError(ErrGeneric, nil, "--watch pushes a single app, choose one with --app"))
+		}
+		names := []string{}
+		for _, app := range apps {
+			names = append(names, app.Name)
+		}
+		if fc.String("output") == outputJSON && fc.Bool("watch") {
+			c.exitWithError(NewError(ErrGeneric, nil, "--watch never finishes, use --output jsonl instead of json"))
+		}
+		if err := c.startReport(fc.String("output"), "fast-push", strings.Join(names, ",")); err != nil {
+			c.exitWithError(err)
+		}
+		if fc.IsSet("external-symlinks") {
+			if err := validSymlinkPolicy(fc.String("external-symlinks")); err != nil {
+				c.exitWithError(NewError(ErrGeneric, err, "Invalid --external-symlinks"))
+			}
+			c.scan.ExternalSymlinks = fc.String("external-symlinks")
+		}
+		restart, err := restartPolicy(fc, config.RestartRules)
+		if err != nil {
+			c.exitWithError(err)
+		}
+		wait := WaitOptions{
+			Enabled:   fc.Bool("wait"),
+			Timeout:   defaultWaitTimeout,
+			HealthURL: fc.String("health-url"),
+		}
+		if fc.IsSet("timeout") {
+			wait.Timeout, err = time.ParseDuration(fc.String("timeout"))
+			if err != nil || wait.Timeout <= 0 {
+				c.exitWithError(NewError(ErrGeneric, err, "Invalid --timeout %s", fc.String("timeout")))
+			}
+		}
+		opts := FastPushOptions{
+			NoDelete:   fc.Bool("no-delete"),
+			Watch:      fc.Bool("watch"),
+			Rehash:     fc.Bool("rehash"),
+			Force:      fc.Bool("force"),
+			Restart:    restart,
+			Wait:       wait,
+			Controller: controllerOptions(fc, config.Controller),
 		}
 		// check if the user asked for a dry run or not
 		if fc.IsSet("dry") {
-			dryRun = fc.Bool("dry")
+			opts.DryRun = fc.Bool("dry")
 		} else {
 			c.ui.Warn("warning: dry run not set, commencing fast push")
 		}
 
 		c.ui.Say("Running the fast-push command")
-		c.ui.Say("Target app: %s \n", args[1])
-		c.FastPush(cliConnection, args[1], dryRun)
+		for _, app := range apps {
+			c.ui.Say("Target app: %s \n", app.Name)
+			if app.Path != "" {
+				c.ui.Say("Local root: %s \n", app.Path)
+			}
+			c.report.SetApp(app.Name)
+			// err is local to this branch, the failure has to be reported here
+			err = inDir(app.Path, func() error {
+				return c.FastPush(cliConnection, app.Name, opts)
+			})
+			if err != nil {
+				c.exitWithError(err)
+			}
+		}
 	} else if args[0] == "fast-push-status" || args[0] == "fps" {
-		c.FastPushStatus(cliConnection, args[1])
+		if len(args) == 1 {
+			c.showUsage(args)
+			return
+		}
+		fc := flags.New()
+		addOutputFlag(fc)
+		addPathFlag(fc)
+		addControllerFlags(fc)
+		if err := fc.Parse(args[1:]...); err != nil {
+			c.exitWithError(err)
+		}
+		if len(fc.Args()) == 0 {
+			c.showUsage(args)
+			return
+		}
+		if err := c.startReport(fc.String("output"), "fast-push-status", fc.Args()[0]); err != nil {
+			c.exitWithError(err)
+		}
+		// Resolved before changing directories, certificate paths are relative to here
+		controllerOpts := controllerOptions(fc, config.Controller)
+		err = inDir(fc.String("path"), func() error {
+			return c.FastPushStatus(cliConnection, fc.Args()[0], controllerOpts)
+		})
+	} else if args[0] == "fast-push-diff" || args[0] == "fpd" {
+		fc := flags.New()
+		fc.NewBoolFlag("stat", "", "show a diffstat instead of the diffs")
+		fc.NewBoolFlag("name-only", "", "only show the names of the changed files")
+		addPathFlag(fc)
+		addControllerFlags(fc)
+		if err := fc.Parse(args[1:]...); err != nil {
+			c.exitWithError(err)
+		}
+		if len(fc.Args()) == 0 {
+			c.showUsage(args)
+			return
+		}
+		diffOpts := DiffOptions{
+			Stat:       fc.Bool("stat"),
+			NameOnly:   fc.Bool("name-only"),
+			Controller: controllerOptions(fc, config.Controller),
+		}
+		err = inDir(fc.String("path"), func() error {
+			return c.FastPushDiff(cliConnection, fc.Args()[0], diffOpts)
+		})
+	} else if args[0] == "fast-push-pull" || args[0] == "fpp" {
+		fc := flags.New()
+		fc.NewBoolFlag("dry", "d", "only show what would be downloaded")
+		fc.NewBoolFlag("force", "f", "overwrite locally modified files")
+		addPathFlag(fc)
+		addControllerFlags(fc)
+		if err := fc.Parse(args[1:]...); err != nil {
+			c.exitWithError(err)
+		}
+		if len(fc.Args()) == 0 {
+			c.showUsage(args)
+			return
+		}
+		pullOpts := PullOptions{
+			DryRun:     fc.Bool("dry"),
+			Force:      fc.Bool("force"),
+			Filters:    fc.Args()[1:],
+			Controller: controllerOptions(fc, config.Controller),
+		}
+		err = inDir(fc.String("path"), func() error {
+			return c.FastPushPull(cliConnection, fc.Args()[0], pullOpts)
+		})
+	} else if args[0] == "fast-push-log" || args[0] == "fpl" {
+		fc := flags.New()
+		addPathFlag(fc)
+		addControllerFlags(fc)
+		if err := fc.Parse(args[1:]...); err != nil {
+			c.exitWithError(err)
+		}
+		if len(fc.Args()) == 0 {
+			c.showUsage(args)
+			return
+		}
+		// Resolved before changing directories, certificate paths are relative to here
+		controllerOpts := controllerOptions(fc, config.Controller)
+		err = inDir(fc.String("path"), func() error {
+			return c.FastPushLog(cliConnection, fc.Args()[0], controllerOpts)
+		})
+	} else if args[0] == "fast-push-rollback" || args[0] == "fpr" {
+		fc := flags.New()
+		fc.NewBoolFlag("dry", "d", "only show what would be restored")
+		fc.NewBoolFlag("restart", "", "always restart the app after the rollback")
+		fc.NewBoolFlag("no-restart", "", "never restart the app after the rollback")
+		addPathFlag(fc)
+		addControllerFlags(fc)
+		if err := fc.Parse(args[1:]...); err != nil {
+			c.exitWithError(err)
+		}
+		if len(fc.Args()) == 0 {
+			c.showUsage(args)
+			return
+		}
+		id := 0
+		if len(fc.Args()) > 1 {
+			id, err = strconv.Atoi(fc.Args()[1])
+			if err != nil || id <= 0 {
+				c.exitWithError(NewError(ErrGeneric, nil, "Invalid push id %s, see cf fast-push-log", fc.Args()[1]))
+			}
+		}
+		var restart RestartPolicy
+		if restart, err = restartPolicy(fc, config.RestartRules); err != nil {
+			c.exitWithError(err)
+		}
+		opts := FastPushOptions{
+			DryRun:     fc.Bool("dry"),
+			Restart:    restart,
+			Controller: controllerOptions(fc, config.Controller),
+		}
+		err = inDir(fc.String("path"), func() error {
+			return c.FastPushRollback(cliConnection, fc.Args()[0], id, opts)
+		})
 	} else {
 		return
 	}
+	if err != nil {
+		c.exitWithError(err)
+	}
+	c.report.Finish(nil)
+}
+
+func (c *FastPushPlugin) FastPushStatus(cliConnection plugin.CliConnection, appName string, controllerOpts ControllerOptions) error {
+	client, err := c.NewControllerClient(cliConnection, appName, controllerOpts)
+	if err != nil {
+		return err
+	}
+	return c.ShowDrift(client)
+}
 
+func (c *FastPushPlugin) FastPushDiff(cliConnection plugin.CliConnection, appName string, opts DiffOptions) error {
+	client, err := c.NewControllerClient(cliConnection, appName, opts.Controller)
+	if err != nil {
+		return err
+	}
+	return c.ShowDiff(client, opts)
 }
 
-func (c *FastPushPlugin) GetAuthToken(cliConnection plugin.CliConnection, appName string) string {
-	app, err := cliConnection.GetApp(appName)
+func (c *FastPushPlugin) FastPushPull(cliConnection plugin.CliConnection, appName string, opts PullOptions) error {
+	client, err := c.NewControllerClient(cliConnection, appName, opts.Controller)
 	if err != nil {
-		panic(err)
+		return err
+	}
+	if opts.DryRun {
+		c.ui.Warn("warning: No files will be written, this is a dry run !!")
 	}
-	return app.Guid
+	return c.Pull(client, opts)
 }
 
-func (c *FastPushPlugin) FastPushStatus(cliConnection plugin.CliConnection, appName string) {
-	authToken := c.GetAuthToken(cliConnection, appName)
+func (c *FastPushPlugin) FastPushLog(cliConnection plugin.CliConnection, appName string, controllerOpts ControllerOptions) error {
+	client, err := c.NewControllerClient(cliConnection, appName, controllerOpts)
+	if err != nil {
+		return err
+	}
+	return c.ShowLog(client)
+}
 
-	apiEndpoint := c.GetApiEndpoint(cliConnection, appName)
-	status := lib.Status{}
-	request := gorequest.New()
-	_, body, err := request.Get(apiEndpoint + "/status").Set("x-auth-token", authToken).End()
+func (c *FastPushPlugin) FastPushRollback(cliConnection plugin.CliConnection, appName string, id int, opts FastPushOptions) error {
+	client, err := c.NewControllerClient(cliConnection, appName, opts.Controller)
 	if err != nil {
-		panic(err)
+		return err
 	}
-	json.Unmarshal([]byte(body), &status)
-	c.ui.Say(status.Health)
+	if opts.DryRun {
+		c.ui.Warn("warning: No changes will be applied, this is a dry run !!")
+	}
+	return c.Rollback(client, appName, id, opts)
 }
 
-func (c *FastPushPlugin) FastPush(cliConnection plugin.CliConnection, appName string, dryRun bool) {
+func (c *FastPushPlugin) FastPush(cliConnection plugin.CliConnection, appName string, opts FastPushOptions) error {
 	// Please check what GetApp returns here
 	// https://github.com/cloudfoundry/cli/blob/master/plugin/models/get_app.go
 
-	authToken := c.GetAuthToken(cliConnection, appName)
+	client, err := c.NewControllerClient(cliConnection, appName, opts.Controller)
+	if err != nil {
+		return err
+	}
 
-	if dryRun {
-		// NEED TO HANDLE DRY RUN
+	if opts.DryRun {
 		c.ui.Warn("warning: No changes will be applied, this is a dry run !!")
 	}
 
-	apiEndpoint := c.GetApiEndpoint(cliConnection, appName)
-	request := gorequest.New()
-	response, body, err := request.Get(apiEndpoint + "/files").Set("x-auth-token", authToken).End()
-	if err != nil {
-		panic(err)
+	if err := c.Push(client, appName, opts, nil); err != nil {
+		return err
 	}
-	if response.StatusCode != http.StatusOK {
-		panic("Unexpected status code received while retrieving filelist")
+	if opts.Watch {
+		// The cache is fresh now, changes are picked up by size and mtime
+		opts.Rehash = false
+		return c.Watch(cliConnection, client, appName, opts)
 	}
-	remoteFiles := map[string]*lib.FileEntry{}
-	json.Unmarshal([]byte(body), &remoteFiles)
+	return nil
+}
 
-	localFiles := lib.ListFiles()
+/*
+*	SyncFiles runs one diff/upload cycle against the controller. When paths is
+*	not nil only those paths (or files below them) are considered, everything
+*	else is left untouched on both sides. The restart action that was sent to
+*	the controller is returned.
+ */
+func (c *FastPushPlugin) SyncFiles(client *ControllerClient, appName string, opts FastPushOptions, paths map[string]bool) (string, error) {
+	remoteFiles, err := client.ListFiles()
+	if err != nil {
+		return restartNone, err
+	}
 
-	filesToUpload := c.ComputeFilesToUpload(localFiles, remoteFiles)
-	payload, _ := json.Marshal(filesToUpload)
-	_, body, err = request.Put(apiEndpoint+"/files").Set("x-auth-token", authToken).Send(string(payload)).End()
+	// Ignored paths are left alone on both sides, they are never uploaded nor deleted
+	localFiles, ignore, err := c.localFiles(client, opts.Rehash)
 	if err != nil {
-		panic(err)
+		return restartNone, err
+	}
+	remoteFiles = ignore.Filter(remoteFiles)
+	if paths != nil {
+		localFiles = filterFiles(localFiles, paths)
+		remoteFiles = filterFiles(remoteFiles, c.paths.RemotePaths(paths))
+	}
+	// From here on local files are known by the path they have in the container
+	if localFiles, err = c.paths.Map(localFiles); err != nil {
+		return restartNone, err
+	}
+
+	filesToUpload, plan := c.ComputeFilesToUpload(localFiles, remoteFiles)
+	if !opts.NoDelete {
+		plan.Deleted = c.ComputeFilesToDelete(localFiles, remoteFiles)
+	}
+	plan.Conflicts = FindConflicts(opts.baseline, remoteFiles, plan)
+	kept := map[string]bool{}
+	if len(plan.Conflicts) > 0 && opts.DryRun {
+		for _, path := range plan.Conflicts {
+			c.ui.Say("[CONFLICT] " + path + " (changed remotely since the last push)")
+		}
+	} else if len(plan.Conflicts) > 0 {
+		switch c.resolveConflicts(plan.Conflicts, opts) {
+		case conflictsAbort:
+			return restartNone, NewError(ErrConflict, nil, "%d file(s) changed remotely since the last push, overwrite them with --force or get them with cf fast-push-pull", len(plan.Conflicts))
+		case conflictsSkip:
+			skipPaths(filesToUpload, plan, plan.Conflicts)
+			for _, path := range plan.Conflicts {
+				kept[path] = true
+			}
+		}
+	}
+	plan.Restart, plan.RestartReason = opts.Restart.Decide(plan)
+	c.reportPlan(client, plan, opts.DryRun)
+	if opts.DryRun {
+		// Only the read-only GET above is allowed to reach the controller
+		c.ShowPlan(appName, plan)
+		return restartNone, nil
+	}
+	if opts.record != nil {
+		if err := opts.history.Capture(client, opts.record, remoteFiles, plan); err != nil {
+			c.ui.Warn("warning: %s, this push cannot be rolled back", err.Error())
+		}
 	}
-	status := lib.Status{}
-	json.Unmarshal([]byte(body), &status)
+	// Deletions go first so that the restart triggered by the upload sees the final tree
+	if len(plan.Deleted) > 0 {
+		payload, _ := json.Marshal(plan.Deleted)
+		response, _, errs := client.Delete("/files").Send(string(payload)).End()
+		if err := checkResponse(response, errs, "deleting files"); err != nil {
+			return restartNone, err
+		}
+	}
+	started := time.Now()
+	status, err := c.UploadFiles(client, filesToUpload, plan.Restart)
+	if err != nil {
+		return restartNone, err
+	}
+	c.report.Event(&Event{Type: "upload", Instance: instanceRef(client), Bytes: &plan.Bytes, DurationMs: millisSince(started), Health: status.Health})
+
+	// The remote files now match the local ones, apart from the kept conflicts
+	for path, entry := range remoteFiles {
+		if !kept[path] && localFiles[path] == nil {
+			if opts.NoDelete {
+				opts.nextBaseline.Record(path, entry)
+			} else {
+				opts.nextBaseline.Record(path, nil)
+			}
+		}
+	}
+	for path, entry := range localFiles {
+		if !kept[path] {
+			opts.nextBaseline.Record(path, entry)
+		}
+	}
+	c.ui.Say("Restart: %s (%s)", plan.Restart, plan.RestartReason)
 	c.ui.Say(status.Health)
+	return plan.Restart, nil
 }
 
 /*
@@ -185,10 +553,23 @@ func (c *FastPushPlugin) GetMetadata() plugin.PluginMetadata {
 				Alias:    "fp",
 				HelpText: "fast-push removes the need to deploy your app again for a small change",
 				UsageDetails: plugin.Usage{
-					Usage: "cf fast-push APP_NAME\n   cf fp APP_NAME",
-					Options: map[string]string{
-						"dry": "--dry, dry run for fast-push",
-					},
+					Usage: "cf fast-push [APP_NAME] [-f MANIFEST] [--app APP_NAME] [-p PATH] [--dry] [--no-delete] [--watch] [--wait [--timeout 2m] [--health-url URL]] [--output json|jsonl]\n   cf fp [APP_NAME] [-f MANIFEST] [--app APP_NAME] [-p PATH] [--dry] [--no-delete] [--watch] [--wait [--timeout 2m] [--health-url URL]] [--output json|jsonl]",
+					Options: withControllerUsage(map[string]string{
+						"dry":               "--dry, show what would be pushed without changing the app",
+						"no-delete":         "--no-delete, keep remote files that were removed locally",
+						"watch":             "--watch, keep running and push changed files as they are saved",
+						"restart":           "--restart, always restart the app after the push",
+						"no-restart":        "--no-restart, never restart the app after the push",
+						"wait":              "--wait, wait until every instance is healthy, fail otherwise",
+						"timeout":           "--timeout, how long --wait waits (default 2m)",
+						"health-url":        "--health-url, URL or path of the app that must answer 2xx for --wait",
+						"output":            "--output, text (default), json or jsonl",
+						"manifest":          "-f, path to the manifest, read to find the apps when no APP_NAME is given (default ./manifest.yml)",
+						"app":               "--app, push only this app of the manifest",
+						"force":             "--force, overwrite files that were changed remotely since the last push",
+						"rehash":            "--rehash, ignore the cached checksums of the local files",
+						"external-symlinks": "--external-symlinks, symlinks leaving the app root: follow (default), preserve, skip or error",
+					}),
 				},
 			},
 			plugin.Command{
@@ -196,7 +577,54 @@ func (c *FastPushPlugin) GetMetadata() plugin.PluginMetadata {
 				Alias:    "fps",
 				HelpText: "fast-push-status shows the current state of your application",
 				UsageDetails: plugin.Usage{
-					Usage: "cf fast-push-status APP_NAME\n   cf fps APP_NAME",
+					Usage:   "cf fast-push-status APP_NAME\n   cf fps APP_NAME",
+					Options: withControllerUsage(map[string]string{}),
+				},
+			},
+			plugin.Command{
+				Name:     "fast-push-diff",
+				Alias:    "fpd",
+				HelpText: "fast-push-diff shows the changes fast-push would make to the files of your application",
+				UsageDetails: plugin.Usage{
+					Usage: "cf fast-push-diff APP_NAME [-p PATH] [--stat | --name-only]\n   cf fpd APP_NAME [-p PATH] [--stat | --name-only]",
+					Options: withControllerUsage(map[string]string{
+						"stat":      "--stat, show a diffstat instead of the diffs",
+						"name-only": "--name-only, only show the names of the changed files",
+					}),
+				},
+			},
+			plugin.Command{
+				Name:     "fast-push-pull",
+				Alias:    "fpp",
+				HelpText: "fast-push-pull downloads the files of your application that differ from the local ones",
+				UsageDetails: plugin.Usage{
+					Usage: "cf fast-push-pull APP_NAME [PATH...] [-p PATH] [--dry] [--force]\n   cf fpp APP_NAME [PATH...] [-p PATH] [--dry] [--force]",
+					Options: withControllerUsage(map[string]string{
+						"dry":   "--dry, only show what would be downloaded",
+						"force": "--force, overwrite files that were modified locally",
+					}),
+				},
+			},
+			plugin.Command{
+				Name:     "fast-push-log",
+				Alias:    "fpl",
+				HelpText: "fast-push-log lists the recorded pushes of your application",
+				UsageDetails: plugin.Usage{
+					Usage:   "cf fast-push-log APP_NAME [-p PATH]\n   cf fpl APP_NAME [-p PATH]",
+					Options: withControllerUsage(map[string]string{}),
+				},
+			},
+			plugin.Command{
+				Name:     "fast-push-rollback",
+				Alias:    "fpr",
+				HelpText: "fast-push-rollback restores the files of your application to their state before a recorded push",
+				UsageDetails: plugin.Usage{
+					Usage: "cf fast-push-rollback APP_NAME [ID] [-p PATH] [--dry] [--restart | --no-restart]\n   cf fpr APP_NAME [ID] [-p PATH] [--dry] [--restart | --no-restart]",
+					Options: withControllerUsage(map[string]string{
+						"dry":        "--dry, only show what would be restored",
+						"restart":    "--restart, always restart the app after the rollback",
+						"no-restart": "--no-restart, never restart the app after the rollback",
+					}),
 				},
 			},
 		},
@@ -221,36 +649,57 @@ func (c *FastPushPlugin) showUsage(args []string) {
 	}
 }
 
-func (c *FastPushPlugin) GetApiEndpoint(cliConnection plugin.CliConnection, appName string) string {
-	results, err := cliConnection.CliCommandWithoutTerminalOutput("app", appName)
-	if err != nil {
-		c.ui.Failed(err.Error())
-	}
-
-	for _, line := range results {
-		match, _ := regexp.MatchString("^urls:.*", line)
-		if match {
-			parts := strings.Fields(line)
-			if len(parts) > 1 {
-				return "https://" + parts[1] + "/_fastpush"
+func (c *FastPushPlugin) ComputeFilesToUpload(local map[string]*FileEntry, remote map[string]*FileEntry) (map[string]*FileEntry, *PushPlan) {
+	filesToUpload := map[string]*FileEntry{}
+	plan := &PushPlan{Sizes: map[string]int64{}}
+	for path, f := range local {
+		if remote[path] == nil {
+			c.ui.Say("[NEW] " + path)
+			plan.New = append(plan.New, path)
+		} else if !sameFile(f, remote[path]) {
+			if change := modeChange(remote[path], f); change != "" && f.Checksum == remote[path].Checksum {
+				c.ui.Say("[MOD] " + path + " (" + change + ")")
+			} else {
+				c.ui.Say("[MOD] " + path)
 			}
+			plan.Modified = append(plan.Modified, path)
+		} else {
+			continue
 		}
+		size := uploadSize(c.paths.LocalPath(path), f)
+		plan.Bytes += size
+		plan.Sizes[path] = size
+		filesToUpload[path] = f
 	}
-	panic("Could not find usable route for this app. Make sure at least one route is mapped to this app")
+	sort.Strings(plan.New)
+	sort.Strings(plan.Modified)
+	return filesToUpload, plan
 }
 
-func (c *FastPushPlugin) ComputeFilesToUpload(local map[string]*lib.FileEntry, remote map[string]*lib.FileEntry) map[string]*lib.FileEntry {
-	filesToUpload := map[string]*lib.FileEntry{}
-	for path, f := range local {
-		if remote[path] == nil {
-			c.ui.Say("[NEW] " + path)
-			f.Content, _ = ioutil.ReadFile(path)
-			filesToUpload[path] = f
-		} else if remote[path].Checksum != f.Checksum {
-			c.ui.Say("[MOD] " + path)
-			f.Content, _ = ioutil.ReadFile(path)
-			filesToUpload[path] = f
+// Remote files without a local counterpart are orphans left behind by earlier pushes
+func (c *FastPushPlugin) ComputeFilesToDelete(local map[string]*FileEntry, remote map[string]*FileEntry) []string {
+	filesToDelete := []string{}
+	for path := range remote {
+		if local[path] == nil {
+			filesToDelete = append(filesToDelete, path)
 		}
 	}
-	return filesToUpload
+	sort.Strings(filesToDelete)
+	for _, path := range filesToDelete {
+		c.ui.Say("[DEL] " + path)
+	}
+	return filesToDelete
+}
+
+func (c *FastPushPlugin) ShowPlan(appName string, plan *PushPlan) {
+	c.ui.Say("")
+	c.ui.Say("Plan for app %s:", appName)
+	table := c.ui.Table([]string{"", ""})
+	table.Add("new:", strconv.Itoa(len(plan.New)))
+	table.Add("modified:", strconv.Itoa(len(plan.Modified)))
+	table.Add("deleted:", strconv.Itoa(len(plan.Deleted)))
+	table.Add("conflicts:", strconv.Itoa(len(plan.Conflicts)))
+	table.Add("transfer:", formatters.ByteSize(plan.Bytes))
+	table.Add("restart:", plan.Restart+" ("+plan.RestartReason+")")
+	table.Print()
 }
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/simonleung8/flags"
+	"gopkg.in/yaml.v2"
+)
+
+// Manifest names cf push looks for in a directory
+var manifestNames = []string{"manifest.yml", "manifest.yaml"}
+
+type ManifestApp struct {
+	Name string `yaml:"name"`
+	Path string `yaml:"path"`
+}
+
+/*
+*	Manifest is the part of a cf push manifest fast-push cares about. Like cf
+*	push, attributes at the top level apply to every app, and a manifest
+*	without an applications list describes a single app.
+ */
+type Manifest struct {
+	ManifestApp  `yaml:",inline"`
+	Applications []ManifestApp `yaml:"applications"`
+}
+
+/*
+*	LoadManifest reads the manifest at path, which may also be the directory
+*	holding it. The paths of the returned apps are resolved relative to the
+*	manifest and default to its directory. A missing manifest is only an
+*	error when required is set.
+ */
+func LoadManifest(path string, required bool) ([]ManifestApp, error) {
+	file, err := findManifest(path)
+	if err != nil {
+		if os.IsNotExist(err) && !required {
+			return nil, nil
+		}
+		return nil, NewError(ErrGeneric, err, "Could not find a manifest at %s", path)
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, NewError(ErrGeneric, err, "Could not read manifest %s", file)
+	}
+	manifest := &Manifest{}
+	if err := yaml.Unmarshal(data, manifest); err != nil {
+		return nil, NewError(ErrGeneric, err, "Could not parse manifest %s", file)
+	}
+
+	apps := manifest.Applications
+	if len(apps) == 0 && manifest.Name != "" {
+		apps = []ManifestApp{manifest.ManifestApp}
+	}
+	dir := filepath.Dir(file)
+	resolved := []ManifestApp{}
+	for _, app := range apps {
+		if app.Name == "" {
+			return nil, NewError(ErrGeneric, nil, "Every app in manifest %s needs a name", file)
+		}
+		if app.Path == "" {
+			app.Path = manifest.Path
+		}
+		if !filepath.IsAbs(app.Path) {
+			app.Path = filepath.Join(dir, app.Path)
+		}
+		resolved = append(resolved, app)
+	}
+	return resolved, nil
+}
+
+func findManifest(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return path, nil
+	}
+	for _, name := range manifestNames {
+		file := filepath.Join(path, name)
+		if _, err := os.Stat(file); err == nil {
+			return file, nil
+		}
+	}
+	return "", &os.PathError{Op: "stat", Path: filepath.Join(path, manifestNames[0]), Err: os.ErrNotExist}
+}
+
+/*
+*	SelectApps resolves which apps to push, the way cf push does: an app name
+*	on the command line wins, the manifest then only provides its path. Without
+*	a name every app of the manifest is pushed, or the one chosen with --app.
+ */
+func SelectApps(manifest []ManifestApp, name string, choice string) ([]ManifestApp, error) {
+	if name != "" && choice != "" && name != choice {
+		return nil, NewError(ErrGeneric, nil, "App %s and --app %s do not match", name, choice)
+	}
+	if name == "" {
+		name = choice
+	}
+	if name == "" {
+		if len(manifest) == 0 {
+			return nil, NewError(ErrGeneric, nil, "No app name given and no apps found in a manifest")
+		}
+		return manifest, nil
+	}
+	for _, app := range manifest {
+		if app.Name == name {
+			return []ManifestApp{app}, nil
+		}
+	}
+	if choice != "" && len(manifest) > 0 {
+		return nil, NewError(ErrGeneric, nil, "App %s not found in the manifest", name)
+	}
+	return []ManifestApp{{Name: name}}, nil
+}
+
+const pathFlagUsage = "-p, local directory holding the app files (default current directory, or path: of the manifest)"
+
+func addPathFlag(fc flags.FlagContext) {
+	fc.NewStringFlag("path", "p", "local directory holding the app files")
+}
+
+// inDir runs fn in dir. The local files are always listed relative to the
+// working directory, so this is how an app path becomes the local root.
+func inDir(dir string, fn func() error) error {
+	if dir == "" {
+		return fn()
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return NewError(ErrGeneric, err, "Could not use app path %s", dir)
+	}
+	if !info.IsDir() {
+		return NewError(ErrGeneric, nil, "App path %s is not a directory, fast-push needs the unpacked app", dir)
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	if err := os.Chdir(dir); err != nil {
+		return NewError(ErrGeneric, err, "Could not use app path %s", dir)
+	}
+	defer os.Chdir(cwd)
+	return fn()
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadManifest(t *testing.T) {
+	inTempDir(t, func() {
+		dir, _ := os.Getwd()
+		os.Mkdir("deploy", 0755)
+		manifest := `---
+path: build
+applications:
+- name: web
+- name: worker
+  path: ../worker
+- name: abs
+  path: /srv/abs
+`
+		if err := ioutil.WriteFile("deploy/manifest.yml", []byte(manifest), 0644); err != nil {
+			t.Fatal(err)
+		}
+		apps, err := LoadManifest("deploy", true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []ManifestApp{
+			{Name: "web", Path: filepath.Join("deploy", "build")},
+			{Name: "worker", Path: "worker"},
+			{Name: "abs", Path: filepath.Clean("/srv/abs")},
+		}
+		if !reflect.DeepEqual(apps, want) {
+			t.Errorf("got %v, want %v", apps, want)
+		}
+
+		// A single app manifest without an applications list
+		if err := ioutil.WriteFile("manifest.yaml", []byte("name: solo\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		apps, err = LoadManifest(dir, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []ManifestApp{{Name: "solo", Path: dir}}; !reflect.DeepEqual(apps, want) {
+			t.Errorf("got %v, want %v", apps, want)
+		}
+	})
+}
+
+func TestLoadManifestMissing(t *testing.T) {
+	inTempDir(t, func() {
+		if apps, err := LoadManifest(".", false); apps != nil || err != nil {
+			t.Errorf("an optional missing manifest gave %v, %v", apps, err)
+		}
+		if _, err := LoadManifest(".", true); err == nil {
+			t.Errorf("a required missing manifest gave no error")
+		}
+		ioutil.WriteFile("manifest.yml", []byte("applications:\n- path: app\n"), 0644)
+		if _, err := LoadManifest(".", false); err == nil {
+			t.Errorf("an app without a name gave no error")
+		}
+	})
+}
+
+func TestSelectApps(t *testing.T) {
+	manifest := []ManifestApp{{Name: "web", Path: "web"}, {Name: "worker", Path: "worker"}}
+	for _, c := range []struct {
+		manifest []ManifestApp
+		name     string
+		choice   string
+		want     []ManifestApp
+	}{
+		{manifest, "", "", manifest},
+		{manifest, "worker", "", []ManifestApp{{Name: "worker", Path: "worker"}}},
+		{manifest, "", "web", []ManifestApp{{Name: "web", Path: "web"}}},
+		{manifest, "web", "web", []ManifestApp{{Name: "web", Path: "web"}}},
+		// A name missing from the manifest is pushed from the current directory
+		{manifest, "other", "", []ManifestApp{{Name: "other"}}},
+		{nil, "other", "", []ManifestApp{{Name: "other"}}},
+		{nil, "", "other", []ManifestApp{{Name: "other"}}},
+	} {
+		got, err := SelectApps(c.manifest, c.name, c.choice)
+		if err != nil || !reflect.DeepEqual(got, c.want) {
+			t.Errorf("SelectApps(%q, %q) = %v, %v, want %v", c.name, c.choice, got, err, c.want)
+		}
+	}
+	for _, c := range []struct {
+		manifest []ManifestApp
+		name     string
+		choice   string
+	}{
+		{manifest, "web", "worker"},
+		{manifest, "", "other"},
+		{nil, "", ""},
+	} {
+		if _, err := SelectApps(c.manifest, c.name, c.choice); err == nil {
+			t.Errorf("SelectApps(%q, %q) gave no error", c.name, c.choice)
+		}
+	}
+}
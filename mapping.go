@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+/*
+*	PathMapping moves local files to another place in the container. Local is
+*	a path prefix, or a glob matching path prefixes, that is replaced by
+*	Remote. When a glob matches the whole path of a file, Remote is the
+*	directory the file lands in.
+ */
+type PathMapping struct {
+	Local  string `yaml:"local"`
+	Remote string `yaml:"remote"`
+}
+
+func (m PathMapping) validate() error {
+	if strings.Trim(m.Local, "/") == "" {
+		return fmt.Errorf("mapping to %q needs a local path", m.Remote)
+	}
+	if _, err := m.compile(); err != nil {
+		return fmt.Errorf("invalid local path %q: %s", m.Local, err.Error())
+	}
+	return nil
+}
+
+func (m PathMapping) isGlob() bool {
+	return strings.ContainsAny(m.Local, "*?[")
+}
+
+// compile matches the local prefix at a path component boundary
+func (m PathMapping) compile() (*regexp.Regexp, error) {
+	return regexp.Compile("^" + globToRegexp(strings.Trim(m.Local, "/")) + "(/|$)")
+}
+
+/*
+*	PathMapper applies the mappings of the project configuration. Local file
+*	listings are re-keyed by remote path with Map, LocalPath finds the file on
+*	disk for a remote path again. A nil PathMapper maps nothing.
+ */
+type PathMapper struct {
+	mappings []PathMapping
+	patterns []*regexp.Regexp
+	local    map[string]string
+}
+
+func NewPathMapper(mappings []PathMapping) (*PathMapper, error) {
+	m := &PathMapper{mappings: mappings, local: map[string]string{}}
+	for _, mapping := range mappings {
+		pattern, err := mapping.compile()
+		if err != nil {
+			return nil, err
+		}
+		m.patterns = append(m.patterns, pattern)
+	}
+	return m, nil
+}
+
+// RemotePath returns where the local path ends up, the first matching mapping wins
+func (m *PathMapper) RemotePath(local string) string {
+	if m == nil {
+		return local
+	}
+	for i, pattern := range m.patterns {
+		match := pattern.FindString(local)
+		if match == "" {
+			continue
+		}
+		remote := strings.Trim(m.mappings[i].Remote, "/")
+		rest := strings.TrimPrefix(local[len(strings.TrimSuffix(match, "/")):], "/")
+		if rest == "" && m.mappings[i].isGlob() {
+			rest = path.Base(local)
+		}
+		return strings.TrimPrefix(path.Join(remote, rest), "/")
+	}
+	return local
+}
+
+// RemotePaths maps a set of changed local paths, keeping the local ones as well
+// since a path outside every mapping is pushed as is
+func (m *PathMapper) RemotePaths(paths map[string]bool) map[string]bool {
+	remote := map[string]bool{}
+	for p := range paths {
+		remote[p] = true
+		remote[m.RemotePath(filepath.ToSlash(p))] = true
+	}
+	return remote
+}
+
+// LocalPath returns the local file of a remote path. Paths that were not seen
+// by Map are only mapped back through plain prefix mappings.
+func (m *PathMapper) LocalPath(remote string) string {
+	if m == nil {
+		return remote
+	}
+	if local, ok := m.local[remote]; ok {
+		return local
+	}
+	for _, mapping := range m.mappings {
+		if mapping.isGlob() {
+			continue
+		}
+		prefix := strings.Trim(mapping.Remote, "/")
+		if prefix == "" {
+			return path.Join(strings.Trim(mapping.Local, "/"), remote)
+		}
+		if remote == prefix || strings.HasPrefix(remote, prefix+"/") {
+			return path.Join(strings.Trim(mapping.Local, "/"), strings.TrimPrefix(remote, prefix))
+		}
+	}
+	return remote
+}
+
+// Map re-keys a local file listing by remote path. Two local files landing on
+// the same remote path are an error, the push would be ambiguous.
+func (m *PathMapper) Map(files map[string]*FileEntry) (map[string]*FileEntry, error) {
+	if m == nil || len(m.mappings) == 0 {
+		return files, nil
+	}
+	locals := make([]string, 0, len(files))
+	for local := range files {
+		locals = append(locals, local)
+	}
+	sort.Strings(locals)
+
+	m.local = map[string]string{}
+	mapped := map[string]*FileEntry{}
+	for _, local := range locals {
+		remote := m.RemotePath(local)
+		if other, ok := m.local[remote]; ok {
+			return nil, NewError(ErrGeneric, nil, "Both %s and %s map to %s, check path_mappings in %s", other, local, remote, projectConfigFile)
+		}
+		m.local[remote] = local
+		mapped[remote] = files[local]
+	}
+	return mapped, nil
+}
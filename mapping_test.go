@@ -0,0 +1,91 @@
+package main
+
+import "testing"
+
+func TestPathMapper(t *testing.T) {
+	mapper, err := NewPathMapper([]PathMapping{
+		{Local: "target/classes", Remote: "BOOT-INF/classes"},
+		{Local: "web/*/dist", Remote: "public"},
+		{Local: "config/*.yml", Remote: "/etc/app/"},
+		{Local: "static", Remote: "/"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		local  string
+		remote string
+	}{
+		{"target/classes/a/B.class", "BOOT-INF/classes/a/B.class"},
+		{"target/classes", "BOOT-INF/classes"},
+		{"target/classesX/B.class", "target/classesX/B.class"},
+		{"web/admin/dist/app.js", "public/app.js"},
+		{"web/admin/src/app.js", "web/admin/src/app.js"},
+		{"config/prod.yml", "etc/app/prod.yml"},
+		{"config/prod.json", "config/prod.json"},
+		{"static/index.html", "index.html"},
+		{"README.md", "README.md"},
+	}
+	for _, test := range tests {
+		if got := mapper.RemotePath(test.local); got != test.remote {
+			t.Errorf("RemotePath(%q) = %q, want %q", test.local, got, test.remote)
+		}
+	}
+
+	// Prefix mappings are reversible without a listing, glob mappings are not
+	reverse := []struct {
+		remote string
+		local  string
+	}{
+		{"BOOT-INF/classes/a/B.class", "target/classes/a/B.class"},
+		{"public/app.js", "static/public/app.js"},
+		{"index.html", "static/index.html"},
+	}
+	for _, test := range reverse {
+		if got := mapper.LocalPath(test.remote); got != test.local {
+			t.Errorf("LocalPath(%q) = %q, want %q", test.remote, got, test.local)
+		}
+	}
+
+	mapped, err := mapper.Map(map[string]*FileEntry{"web/admin/dist/app.js": {Checksum: "a"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mapped["public/app.js"] == nil || mapper.LocalPath("public/app.js") != "web/admin/dist/app.js" {
+		t.Errorf("Map did not remember the local path of public/app.js: %v", mapped)
+	}
+}
+
+func TestPathMapperCollision(t *testing.T) {
+	mapper, _ := NewPathMapper([]PathMapping{{Local: "web/*/dist", Remote: "public"}})
+	_, err := mapper.Map(map[string]*FileEntry{
+		"web/a/dist/app.js": {Checksum: "a"},
+		"web/b/dist/app.js": {Checksum: "b"},
+	})
+	if err == nil {
+		t.Error("two local files mapped to public/app.js without an error")
+	}
+}
+
+func TestPathMappingValidate(t *testing.T) {
+	tests := []struct {
+		mapping PathMapping
+		valid   bool
+	}{
+		{PathMapping{Local: "a", Remote: "b"}, true},
+		{PathMapping{Local: "/", Remote: "b"}, false},
+		{PathMapping{Local: "", Remote: "b"}, false},
+	}
+	for _, test := range tests {
+		if err := test.mapping.validate(); (err == nil) != test.valid {
+			t.Errorf("validate(%+v) = %v, want valid %v", test.mapping, err, test.valid)
+		}
+	}
+}
+
+func TestNilPathMapper(t *testing.T) {
+	var mapper *PathMapper
+	if mapper.RemotePath("a/b") != "a/b" || mapper.LocalPath("a/b") != "a/b" {
+		t.Error("a nil PathMapper must not map")
+	}
+}
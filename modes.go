@@ -0,0 +1,135 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+/*
+*	FileEntry is a file as the plugin and the controller list it: the
+*	lib.FileEntry of the controller plus the permission bits and, for a
+*	symlink, its target. Controllers that do not know about modes and links
+*	leave them empty.
+ */
+type FileEntry struct {
+	Checksum string
+	Content  []byte
+	Mode     os.FileMode `json:",omitempty"`
+	Link     string      `json:",omitempty"`
+}
+
+// sameFile compares content, link target and permissions. Modes are only
+// compared when both sides know them.
+func sameFile(a *FileEntry, b *FileEntry) bool {
+	if a.Checksum != b.Checksum || a.Link != b.Link {
+		return false
+	}
+	return a.Mode == 0 || b.Mode == 0 || a.Mode.Perm() == b.Mode.Perm()
+}
+
+// modeChange describes a permission-only difference, "" when there is none
+func modeChange(from *FileEntry, to *FileEntry) string {
+	if from.Mode == 0 || to.Mode == 0 || from.Mode.Perm() == to.Mode.Perm() {
+		return ""
+	}
+	return fmt.Sprintf("mode %o -> %o", from.Mode.Perm(), to.Mode.Perm())
+}
+
+// uploadSize is what pushing the entry transfers, links carry no content
+func uploadSize(localPath string, entry *FileEntry) int64 {
+	if entry.Link != "" {
+		return 0
+	}
+	if entry.Content != nil {
+		return int64(len(entry.Content))
+	}
+	if info, err := os.Stat(localPath); err == nil {
+		return info.Size()
+	}
+	return 0
+}
+
+// What to do with symlinks pointing outside the app root
+const (
+	symlinksFollow   = "follow"
+	symlinksPreserve = "preserve"
+	symlinksSkip     = "skip"
+	symlinksError    = "error"
+)
+
+func validSymlinkPolicy(policy string) error {
+	switch policy {
+	case symlinksFollow, symlinksPreserve, symlinksSkip, symlinksError:
+		return nil
+	}
+	return fmt.Errorf("unknown symlink policy %q, use follow, preserve, skip or error", policy)
+}
+
+// ScanOptions control how LocalFiles lists the local tree
+type ScanOptions struct {
+	Rehash           bool
+	ExternalSymlinks string
+	// Set when the controller cannot store links, every link is followed then
+	FollowLinks bool
+}
+
+/*
+*	scanSymlink decides how a symlink takes part in a push. Links inside the
+*	app root are pushed as links. Links leaving the root, including every
+*	absolute link since it would not resolve in the container, follow the
+*	policy. When the controller cannot store links they are all followed,
+*	preserve then means follow too. follow is returned when the link should
+*	be pushed as the file it points to.
+ */
+func scanSymlink(path string, opts ScanOptions) (entry *FileEntry, follow bool, err error) {
+	target, err := os.Readlink(path)
+	if err != nil {
+		return nil, false, NewError(ErrGeneric, err, "Could not read symlink %s", path)
+	}
+	external := outsideRoot(path, target)
+	policy := opts.ExternalSymlinks
+	if !external || policy == symlinksPreserve {
+		if !opts.FollowLinks {
+			return &FileEntry{Checksum: linkChecksum(target), Link: filepath.ToSlash(target)}, false, nil
+		}
+		policy = symlinksFollow
+	}
+	switch policy {
+	case symlinksSkip:
+		return nil, false, nil
+	case symlinksError:
+		return nil, false, NewError(ErrGeneric, nil, "Symlink %s points outside the app root to %s", path, target)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, false, NewError(ErrGeneric, err, "Could not follow symlink %s", path)
+	}
+	if info.IsDir() && !external {
+		// The files of the directory are pushed under their own path already
+		return nil, false, nil
+	}
+	if info.IsDir() {
+		return nil, false, NewError(ErrGeneric, nil, "Symlink %s points to the directory %s outside the app root, use --external-symlinks skip or preserve", path, target)
+	}
+	return nil, true, nil
+}
+
+// outsideRoot tells whether the target of the symlink at path, relative to
+// the app root, resolves outside of it
+func outsideRoot(path string, target string) bool {
+	if filepath.IsAbs(target) {
+		return true
+	}
+	resolved := filepath.Join(filepath.Dir(path), target)
+	return resolved == ".." || strings.HasPrefix(resolved, ".."+string(filepath.Separator))
+}
+
+// Links have no content of their own, their checksum covers the target
+func linkChecksum(target string) string {
+	sum := md5.Sum([]byte(target))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestScanSymlink(t *testing.T) {
+	inTempDir(t, func() {
+		outside, _ := ioutil.TempDir("", "outside")
+		defer os.RemoveAll(outside)
+		ioutil.WriteFile(filepath.Join(outside, "shared.txt"), []byte("x"), 0644)
+		ioutil.WriteFile("real.txt", []byte("x"), 0644)
+		os.Mkdir("dir", 0755)
+		os.Symlink("real.txt", "inside")
+		os.Symlink("dir", "inside-dir")
+		os.Symlink(filepath.Join(outside, "shared.txt"), "external")
+		os.Symlink(outside, "external-dir")
+
+		tests := []struct {
+			path   string
+			opts   ScanOptions
+			link   bool
+			follow bool
+			err    bool
+		}{
+			{"inside", ScanOptions{ExternalSymlinks: symlinksFollow}, true, false, false},
+			{"inside", ScanOptions{ExternalSymlinks: symlinksFollow, FollowLinks: true}, false, true, false},
+			{"inside-dir", ScanOptions{ExternalSymlinks: symlinksFollow, FollowLinks: true}, false, false, false},
+			{"external", ScanOptions{ExternalSymlinks: symlinksFollow}, false, true, false},
+			{"external", ScanOptions{ExternalSymlinks: symlinksPreserve}, true, false, false},
+			{"external", ScanOptions{ExternalSymlinks: symlinksPreserve, FollowLinks: true}, false, true, false},
+			{"external", ScanOptions{ExternalSymlinks: symlinksSkip}, false, false, false},
+			{"external", ScanOptions{ExternalSymlinks: symlinksSkip, FollowLinks: true}, false, false, false},
+			{"external", ScanOptions{ExternalSymlinks: symlinksError}, false, false, true},
+			{"external-dir", ScanOptions{ExternalSymlinks: symlinksFollow}, false, false, true},
+		}
+		for _, test := range tests {
+			entry, follow, err := scanSymlink(test.path, test.opts)
+			if (entry != nil && entry.Link != "") != test.link || follow != test.follow || (err != nil) != test.err {
+				t.Errorf("scanSymlink(%q, %+v) = %+v, %v, %v", test.path, test.opts, entry, follow, err)
+			}
+		}
+	})
+}
+
+func TestOutsideRoot(t *testing.T) {
+	tests := []struct {
+		path, target string
+		outside      bool
+	}{
+		{"a", "b", false},
+		{"dir/a", "../b", false},
+		{"a", "../b", true},
+		{"dir/a", "../../b", true},
+		{"a", "/etc/passwd", true},
+		{"a", "..b", false},
+	}
+	for _, test := range tests {
+		if got := outsideRoot(test.path, test.target); got != test.outside {
+			t.Errorf("outsideRoot(%q, %q) = %v, want %v", test.path, test.target, got, test.outside)
+		}
+	}
+}
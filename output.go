@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"time"
+
+	"code.cloudfoundry.org/cli/cf/terminal"
+	"code.cloudfoundry.org/cli/cf/trace"
+	"github.com/simonleung8/flags"
+)
+
+// Output formats of --output. The schema of the json formats is documented in
+// the README, bump outputSchemaVersion on incompatible changes.
+const (
+	outputText          = "text"
+	outputJSON          = "json"
+	outputJSONL         = "jsonl"
+	outputSchemaVersion = 1
+)
+
+/*
+*	Event is one entry of the machine readable output. Type tells which of
+*	the other fields are set:
+*
+*	Every event also carries the app it is about.
+*
+*	start    schema_version, command, app
+*	file     instance, action (new, modified, deleted), path, bytes
+*	plan     instance, plan
+*	upload   instance, bytes, duration_ms, health
+*	instance instance, ok, error (result of one instance of a multi-instance push)
+*	status   instance, health, files, differ_local, differ_others, error
+*	wait     ok, duration_ms
+*	end      ok, duration_ms, error
+ */
+type Event struct {
+	Type          string       `json:"type"`
+	Time          time.Time    `json:"time"`
+	SchemaVersion int          `json:"schema_version,omitempty"`
+	Command       string       `json:"command,omitempty"`
+	App           string       `json:"app,omitempty"`
+	Instance      *int         `json:"instance,omitempty"`
+	Action        string       `json:"action,omitempty"`
+	Path          string       `json:"path,omitempty"`
+	Bytes         *int64       `json:"bytes,omitempty"`
+	Plan          *PlanReport  `json:"plan,omitempty"`
+	Health        string       `json:"health,omitempty"`
+	Files         *int         `json:"files,omitempty"`
+	DifferLocal   *int         `json:"differ_local,omitempty"`
+	DifferOthers  *int         `json:"differ_others,omitempty"`
+	OK            *bool        `json:"ok,omitempty"`
+	DurationMs    *int64       `json:"duration_ms,omitempty"`
+	Error         *ErrorReport `json:"error,omitempty"`
+}
+
+type PlanReport struct {
+	DryRun        bool     `json:"dry_run"`
+	New           []string `json:"new"`
+	Modified      []string `json:"modified"`
+	Deleted       []string `json:"deleted"`
+	Conflicts     []string `json:"conflicts"`
+	Bytes         int64    `json:"bytes"`
+	Restart       string   `json:"restart"`
+	RestartReason string   `json:"restart_reason"`
+}
+
+type ErrorReport struct {
+	Code    int    `json:"code"`
+	Kind    string `json:"kind"`
+	Message string `json:"message"`
+}
+
+// Document is what --output json prints once the command finished
+type Document struct {
+	SchemaVersion int          `json:"schema_version"`
+	Command       string       `json:"command"`
+	App           string       `json:"app"`
+	StartedAt     time.Time    `json:"started_at"`
+	DurationMs    int64        `json:"duration_ms"`
+	OK            bool         `json:"ok"`
+	Error         *ErrorReport `json:"error,omitempty"`
+	Events        []*Event     `json:"events"`
+}
+
+/*
+*	Reporter writes the machine readable output. With jsonl every event is
+*	written as a line as soon as it happens, with json they are collected and
+*	written as one Document by Finish. A nil Reporter is the text format and
+*	discards everything.
+ */
+type Reporter struct {
+	format   string
+	out      io.Writer
+	app      string
+	document *Document
+	finished bool
+}
+
+func NewReporter(format string, out io.Writer, command string, appName string) *Reporter {
+	r := &Reporter{
+		format: format,
+		out:    out,
+		app:    appName,
+		document: &Document{
+			SchemaVersion: outputSchemaVersion,
+			Command:       command,
+			App:           appName,
+			StartedAt:     time.Now(),
+			Events:        []*Event{},
+		},
+	}
+	r.Event(&Event{Type: "start", SchemaVersion: outputSchemaVersion, Command: command, App: appName})
+	return r
+}
+
+func (r *Reporter) Event(event *Event) {
+	if r == nil || r.finished {
+		return
+	}
+	event.Time = time.Now()
+	if event.App == "" {
+		event.App = r.app
+	}
+	if r.format == outputJSONL {
+		line, _ := json.Marshal(event)
+		r.out.Write(append(line, '\n'))
+		return
+	}
+	r.document.Events = append(r.document.Events, event)
+}
+
+// SetApp sets the app the following events are about, for commands handling several apps
+func (r *Reporter) SetApp(appName string) {
+	if r != nil {
+		r.app = appName
+	}
+}
+
+// Finish writes the outcome of the command, err is nil on success
+func (r *Reporter) Finish(err error) {
+	if r == nil || r.finished {
+		return
+	}
+	duration := millisSince(r.document.StartedAt)
+	r.document.DurationMs = *duration
+	r.document.OK = err == nil
+	if err != nil {
+		r.document.Error = errorReport(err)
+	}
+	r.Event(&Event{Type: "end", OK: boolValue(err == nil), DurationMs: duration, Error: r.document.Error})
+	r.finished = true
+	if r.format == outputJSON {
+		document, _ := json.MarshalIndent(r.document, "", "  ")
+		r.out.Write(append(document, '\n'))
+	}
+}
+
+func errorReport(err error) *ErrorReport {
+	code := ExitCode(err)
+	return &ErrorReport{Code: code, Kind: ErrorKind(code).String(), Message: err.Error()}
+}
+
+func addOutputFlag(fc flags.FlagContext) {
+	fc.NewStringFlag("output", "o", "output format: text, json or jsonl")
+}
+
+/*
+*	startReport switches to the requested output format. Stdout is reserved
+*	for the json output, so the human readable output moves to stderr.
+ */
+func (c *FastPushPlugin) startReport(format string, command string, appName string) error {
+	switch format {
+	case "", outputText:
+		return nil
+	case outputJSON, outputJSONL:
+	default:
+		return NewError(ErrGeneric, nil, "Unsupported output format %s, use text, json or jsonl", format)
+	}
+	traceLogger := trace.NewLogger(os.Stderr, true, os.Getenv("CF_TRACE"), "")
+	c.ui = terminal.NewUI(os.Stdin, os.Stderr, terminal.NewTeePrinter(os.Stderr), traceLogger)
+	c.report = NewReporter(format, os.Stdout, command, appName)
+	return nil
+}
+
+// instanceRef is nil for a client that is not pinned to an instance
+func instanceRef(client *ControllerClient) *int {
+	if client.Instance == anyInstance {
+		return nil
+	}
+	index := client.Instance
+	return &index
+}
+
+func (c *FastPushPlugin) reportPlan(client *ControllerClient, plan *PushPlan, dryRun bool) {
+	if c.report == nil {
+		return
+	}
+	instance := instanceRef(client)
+	for _, group := range []struct {
+		action string
+		paths  []string
+	}{{"new", plan.New}, {"modified", plan.Modified}, {"deleted", plan.Deleted}} {
+		for _, path := range group.paths {
+			event := &Event{Type: "file", Instance: instance, Action: group.action, Path: path}
+			if size, ok := plan.Sizes[path]; ok {
+				event.Bytes = &size
+			}
+			c.report.Event(event)
+		}
+	}
+	c.report.Event(&Event{Type: "plan", Instance: instance, Plan: &PlanReport{
+		DryRun:        dryRun,
+		New:           nonNil(plan.New),
+		Modified:      nonNil(plan.Modified),
+		Deleted:       nonNil(plan.Deleted),
+		Conflicts:     nonNil(plan.Conflicts),
+		Bytes:         plan.Bytes,
+		Restart:       plan.Restart,
+		RestartReason: plan.RestartReason,
+	}})
+}
+
+// Empty lists are written as [] rather than null
+func nonNil(paths []string) []string {
+	if paths == nil {
+		return []string{}
+	}
+	return paths
+}
+
+func boolValue(b bool) *bool {
+	return &b
+}
+
+func intValue(i int) *int {
+	return &i
+}
+
+func millisSince(start time.Time) *int64 {
+	ms := time.Since(start).Nanoseconds() / int64(time.Millisecond)
+	return &ms
+}
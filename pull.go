@@ -0,0 +1,203 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type PullOptions struct {
+	DryRun     bool
+	Force      bool
+	Filters    []string
+	Controller ControllerOptions
+}
+
+/*
+*	Pull downloads the remote files that differ from the local tree. Local
+*	files still matching the baseline of the last push only changed remotely
+*	and are updated. Files changed or deleted locally since then hold unpushed
+*	work, like every differing file when there is no baseline, and are only
+*	overwritten with --force.
+ */
+func (c *FastPushPlugin) Pull(client *ControllerClient, opts PullOptions) error {
+	target := client.InstanceClients()[0]
+	remote, err := target.ListFiles()
+	if err != nil {
+		return err
+	}
+	local, ignore, err := c.localFiles(target, false)
+	if err != nil {
+		return err
+	}
+	if local, err = c.paths.Map(local); err != nil {
+		return err
+	}
+	remote = ignore.Filter(remote)
+	baseline := LoadBaseline(target.AppGuid)
+
+	paths := []string{}
+	for p, f := range remote {
+		if outsideAppRoot(c.paths.LocalPath(p)) {
+			return NewError(ErrGeneric, nil, "The controller listed %s, which is outside the app root", p)
+		}
+		if local[p] != nil && sameFile(local[p], f) {
+			continue
+		}
+		if matchesFilters(p, opts.Filters) {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+
+	skipped := 0
+	for _, p := range paths {
+		if change := localChange(baseline, p, local[p]); change != "" && !opts.Force {
+			c.ui.Say("[SKIP] " + p + " (" + change + ", use --force to overwrite)")
+			skipped++
+			continue
+		}
+		if local[p] == nil {
+			c.ui.Say("[NEW] " + p)
+		} else {
+			c.ui.Say("[MOD] " + p)
+		}
+		if opts.DryRun {
+			continue
+		}
+		// Links written by this pull may redirect later paths
+		if err := checkLocalParents(c.paths.LocalPath(p)); err != nil {
+			return err
+		}
+		if remote[p].Link != "" {
+			if err := writeLocalLink(c.paths.LocalPath(p), remote[p].Link); err != nil {
+				return err
+			}
+			continue
+		}
+		content, err := target.FileContent(p)
+		if err != nil {
+			return err
+		}
+		if err := writeLocalFile(c.paths.LocalPath(p), content, remote[p].Mode); err != nil {
+			return err
+		}
+	}
+	if skipped > 0 {
+		c.ui.Warn("warning: %d file(s) with local changes were not overwritten", skipped)
+	}
+	return nil
+}
+
+// localChange tells how the local version of p differs from what the last
+// push sent, "" when pulling it loses nothing. Without a baseline any local
+// file may hold unpushed work.
+func localChange(baseline *Baseline, p string, local *FileEntry) string {
+	pushed, ok := baseline.Files[p]
+	switch {
+	case !baseline.known && local != nil:
+		return "differs locally, no push recorded"
+	case !baseline.known:
+		return ""
+	case local == nil && ok:
+		return "deleted locally since the last push"
+	case local != nil && (!ok || pushed != fingerprint(local)):
+		return "changed locally since the last push"
+	}
+	return ""
+}
+
+// matchesFilters accepts a path equal to, below or glob matching one of the
+// filters. No filters means every path.
+func matchesFilters(p string, filters []string) bool {
+	if len(filters) == 0 {
+		return true
+	}
+	p = filepath.ToSlash(p)
+	for _, filter := range filters {
+		filter = strings.TrimSuffix(filepath.ToSlash(filepath.Clean(filter)), "/")
+		if filter == "." || p == filter || strings.HasPrefix(p, filter+"/") {
+			return true
+		}
+		if matched, _ := path.Match(filter, p); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// outsideAppRoot tells whether a path from the controller would be written
+// outside the working directory, as an absolute path or by leaving it with ..
+func outsideAppRoot(p string) bool {
+	clean := filepath.Clean(filepath.FromSlash(p))
+	if strings.HasPrefix(p, "/") || filepath.IsAbs(clean) || filepath.VolumeName(clean) != "" {
+		return true
+	}
+	return clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator))
+}
+
+/*
+*	checkLocalParents refuses to write p through a symlinked parent directory
+*	that resolves outside the app root. Only the deepest existing parent needs
+*	to be resolved, the ones below it are created by the pull.
+ */
+func checkLocalParents(p string) error {
+	root, err := filepath.EvalSymlinks(".")
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(filepath.FromSlash(p))
+	for dir != "." {
+		if _, err := os.Lstat(dir); err == nil {
+			break
+		}
+		dir = filepath.Dir(dir)
+	}
+	resolved, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return NewError(ErrGeneric, err, "Could not resolve the directory of %s", p)
+	}
+	rel, err := filepath.Rel(root, resolved)
+	if err != nil || outsideAppRoot(rel) {
+		return NewError(ErrGeneric, nil, "Refusing to write %s through a symlink leaving the app root", p)
+	}
+	return nil
+}
+
+// writeLocalFile replaces the content of a file. The mode of the remote file
+// is applied when the controller lists it, otherwise an existing file keeps its own.
+func writeLocalFile(p string, content []byte, mode os.FileMode) error {
+	if info, err := os.Lstat(p); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		// Write a file in place of the link rather than through it
+		if err := os.Remove(p); err != nil {
+			return err
+		}
+	} else if err == nil && mode == 0 {
+		mode = info.Mode().Perm()
+	}
+	if mode == 0 {
+		mode = 0644
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(p, content, mode.Perm()); err != nil {
+		return err
+	}
+	// WriteFile only applies the mode to new files
+	return os.Chmod(p, mode.Perm())
+}
+
+// writeLocalLink replaces whatever is at p with a symlink to target
+func writeLocalLink(p string, target string) error {
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.Symlink(filepath.FromSlash(target), p)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestOutsideAppRoot(t *testing.T) {
+	tests := []struct {
+		path    string
+		outside bool
+	}{
+		{"app.js", false},
+		{"lib/app.js", false},
+		{"lib/../app.js", false},
+		{"..foo/app.js", false},
+		{"../app.js", true},
+		{"../../.bashrc", true},
+		{"lib/../../app.js", true},
+		{"..", true},
+		{"/home/u/.ssh/authorized_keys", true},
+	}
+	for _, test := range tests {
+		if got := outsideAppRoot(test.path); got != test.outside {
+			t.Errorf("outsideAppRoot(%q) = %v, want %v", test.path, got, test.outside)
+		}
+	}
+}
+
+func TestCheckLocalParents(t *testing.T) {
+	inTempDir(t, func() {
+		os.MkdirAll("lib/real", 0755)
+		os.Symlink("real", "lib/inside")
+		os.Symlink(os.TempDir(), "escape")
+		tests := []struct {
+			path string
+			ok   bool
+		}{
+			{"app.js", true},
+			{"lib/real/a.js", true},
+			{"lib/inside/a.js", true},
+			{"new/dir/a.js", true},
+			{"escape/a.js", false},
+			{"escape/new/a.js", false},
+		}
+		for _, test := range tests {
+			if err := checkLocalParents(test.path); (err == nil) != test.ok {
+				t.Errorf("checkLocalParents(%q) = %v, want ok %v", test.path, err, test.ok)
+			}
+		}
+	})
+}
+
+func TestLocalChange(t *testing.T) {
+	baseline := &Baseline{known: true, Files: map[string]string{"pushed.txt": "1", "link": "link:pushed.txt"}, Stale: map[string][]string{}}
+	none := &Baseline{Files: map[string]string{}, Stale: map[string][]string{}}
+	for _, c := range []struct {
+		baseline *Baseline
+		path     string
+		local    *FileEntry
+		changed  bool
+	}{
+		// Only changed remotely
+		{baseline, "pushed.txt", &FileEntry{Checksum: "1"}, false},
+		{baseline, "link", &FileEntry{Link: "pushed.txt"}, false},
+		{baseline, "remote-only.txt", nil, false},
+		// Local work since the last push
+		{baseline, "pushed.txt", &FileEntry{Checksum: "2"}, true},
+		{baseline, "pushed.txt", nil, true},
+		{baseline, "link", &FileEntry{Link: "other.txt"}, true},
+		{baseline, "created.txt", &FileEntry{Checksum: "3"}, true},
+		// Nothing is known without a baseline
+		{none, "pushed.txt", &FileEntry{Checksum: "1"}, true},
+		{none, "remote-only.txt", nil, false},
+	} {
+		if got := localChange(c.baseline, c.path, c.local); (got != "") != c.changed {
+			t.Errorf("%s %+v (baseline known %v): got %q", c.path, c.local, c.baseline.known, got)
+		}
+	}
+}
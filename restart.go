@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/simonleung8/flags"
+)
+
+// What the controller does once the files are in place, by increasing impact
+const (
+	restartNone      = "none"
+	restartApp       = "restart"
+	restartReinstall = "reinstall"
+)
+
+var restartImpact = map[string]int{
+	restartNone:      0,
+	restartApp:       1,
+	restartReinstall: 2,
+}
+
+// RestartRule maps changed paths matching a gitignore style pattern to an action
+type RestartRule struct {
+	Pattern string `yaml:"pattern"`
+	Action  string `yaml:"action"`
+}
+
+func (r RestartRule) validate() error {
+	if _, ok := restartImpact[r.Action]; !ok {
+		return fmt.Errorf("unknown action %q for pattern %q, use none, restart or reinstall", r.Action, r.Pattern)
+	}
+	_, ok, err := parseIgnoreLine(r.Pattern)
+	if err != nil {
+		return fmt.Errorf("invalid pattern %q: %s", r.Pattern, err.Error())
+	}
+	if !ok {
+		return fmt.Errorf("empty pattern for action %q", r.Action)
+	}
+	return nil
+}
+
+// matches tells whether the rule applies to path. Like in .cfignore a pattern
+// matching a directory matches the files within it. A pattern starting with
+// "!" applies to the paths the rest of it does not match.
+func (r RestartRule) matches(path string) bool {
+	rule, ok, err := parseIgnoreLine(r.Pattern)
+	if !ok || err != nil {
+		return false
+	}
+	negate := rule.negate
+	rule.negate = false
+	single := &IgnoreRules{rules: []ignoreRule{rule}}
+	return single.Ignored(path, false) != negate
+}
+
+/*
+*	RestartPolicy decides what happens after a push. Every changed path takes
+*	the action of the first rule matching it, paths without a rule restart the
+*	app, and the change set gets the action with the highest impact. Force,
+*	set by --restart or --no-restart, overrides the rules.
+ */
+type RestartPolicy struct {
+	Force string
+	Rules []RestartRule
+}
+
+// Decide returns the action for the change set and why it was chosen
+func (p RestartPolicy) Decide(plan *PushPlan) (string, string) {
+	if p.Force == restartNone {
+		return restartNone, "--no-restart"
+	}
+	paths := plan.Paths()
+	if len(paths) == 0 {
+		if p.Force == restartApp {
+			return restartApp, "--restart"
+		}
+		return restartNone, "nothing changed"
+	}
+
+	action, reason := restartNone, ""
+	for _, path := range paths {
+		pathAction, rule := restartApp, "no matching rule"
+		for _, r := range p.Rules {
+			if r.matches(path) {
+				pathAction, rule = r.Action, "rule "+r.Pattern
+				break
+			}
+		}
+		if restartImpact[pathAction] > restartImpact[action] {
+			action, reason = pathAction, path+": "+rule
+		}
+	}
+	if reason == "" {
+		reason = "only files without restart impact changed"
+	}
+	if p.Force == restartApp && restartImpact[action] < restartImpact[restartApp] {
+		return restartApp, "--restart"
+	}
+	return action, reason
+}
+
+// restartPolicy builds the policy of a command from the rules of the project
+// configuration and its --restart and --no-restart flags
+func restartPolicy(fc flags.FlagContext, rules []RestartRule) (RestartPolicy, error) {
+	policy := RestartPolicy{Rules: rules}
+	if fc.Bool("restart") && fc.Bool("no-restart") {
+		return policy, NewError(ErrGeneric, nil, "--restart and --no-restart cannot be combined")
+	}
+	if fc.Bool("restart") {
+		policy.Force = restartApp
+	} else if fc.Bool("no-restart") {
+		policy.Force = restartNone
+	}
+	return policy, nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import "testing"
+
+func changed(paths ...string) *PushPlan {
+	return &PushPlan{Modified: paths, Sizes: map[string]int64{}}
+}
+
+func TestDecide(t *testing.T) {
+	rules := []RestartRule{
+		{Pattern: "static/", Action: restartNone},
+		{Pattern: "/docs", Action: restartNone},
+		{Pattern: "*.css", Action: restartNone},
+		{Pattern: "/package.json", Action: restartReinstall},
+		{Pattern: "!/src/", Action: restartNone},
+	}
+	for _, c := range []struct {
+		force  string
+		paths  []string
+		action string
+	}{
+		{"", nil, restartNone},
+		{restartApp, nil, restartApp},
+		{restartNone, nil, restartNone},
+		{"", []string{"static/css/site.css"}, restartNone},
+		{"", []string{"docs/index.md", "docs/img/logo.png"}, restartNone},
+		{"", []string{"src/docs/app.py"}, restartApp},
+		{"", []string{"src/app.py", "static/site.css"}, restartApp},
+		{"", []string{"README.md"}, restartNone},
+		{"", []string{"src/site.css"}, restartNone},
+		{"", []string{"package.json", "src/app.py"}, restartReinstall},
+		{restartApp, []string{"static/site.css"}, restartApp},
+		{restartApp, []string{"package.json"}, restartReinstall},
+		{restartNone, []string{"package.json"}, restartNone},
+	} {
+		policy := RestartPolicy{Force: c.force, Rules: rules}
+		if action, reason := policy.Decide(changed(c.paths...)); action != c.action {
+			t.Errorf("force %q, %v: got %s (%s), want %s", c.force, c.paths, action, reason, c.action)
+		}
+	}
+}
+
+func TestDecideWithoutRules(t *testing.T) {
+	if action, _ := (RestartPolicy{}).Decide(changed("index.html")); action != restartApp {
+		t.Errorf("a change without rules gives %s, want %s", action, restartApp)
+	}
+}
+
+func TestValidateRestartRule(t *testing.T) {
+	for _, r := range []RestartRule{
+		{Pattern: "*.css", Action: "reload"},
+		{Pattern: "", Action: restartNone},
+	} {
+		if r.validate() == nil {
+			t.Errorf("%+v accepted", r)
+		}
+	}
+	if err := (RestartRule{Pattern: "!static/", Action: restartNone}).validate(); err != nil {
+		t.Errorf("negated directory rule rejected: %s", err.Error())
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"code.cloudfoundry.org/cli/plugin/models"
+)
+
+// Routes on this domain only resolve inside the platform (container networking)
+const internalDomain = "apps.internal"
+
+const defaultControllerPrefix = "/_fastpush"
+
+// ControllerOptions overrides where and how the controller is reached, empty
+// fields fall back to what the app routes and the CLI dictate
+type ControllerOptions struct {
+	Endpoint   string `yaml:"endpoint"`
+	Scheme     string `yaml:"scheme"`
+	Prefix     string `yaml:"prefix"`
+	CACert     string `yaml:"ca_cert"`
+	ClientCert string `yaml:"client_cert"`
+	ClientKey  string `yaml:"client_key"`
+	// Send the access token over plain http to a controller on another host
+	AllowHTTPToken bool `yaml:"allow_http_token"`
+}
+
+/*
+*	GetApiEndpoint derives the controller URL from the routes mapped to the app.
+*	When several routes are mapped the preference is: HTTP routes without a
+*	context path, HTTP routes with a context path, then TCP routes. Internal
+*	routes are skipped since the controller must be reachable from here.
+*	An explicit endpoint, scheme or prefix in opts replaces the derived one.
+ */
+func (c *FastPushPlugin) GetApiEndpoint(app plugin_models.GetAppModel, opts ControllerOptions) (string, error) {
+	appURL, err := c.GetAppURL(app, opts)
+	if err != nil {
+		return "", err
+	}
+	prefix := defaultControllerPrefix
+	if opts.Prefix != "" {
+		prefix = "/" + strings.Trim(opts.Prefix, "/")
+	}
+	return appURL + strings.TrimRight(prefix, "/"), nil
+}
+
+// GetAppURL is the location the controller is served below, usually the app route
+func (c *FastPushPlugin) GetAppURL(app plugin_models.GetAppModel, opts ControllerOptions) (string, error) {
+	scheme, host := "", ""
+	if opts.Endpoint != "" {
+		scheme, host = "https", opts.Endpoint
+		if parts := strings.SplitN(opts.Endpoint, "://", 2); len(parts) == 2 {
+			scheme, host = parts[0], parts[1]
+		}
+	} else {
+		route, err := preferredRoute(app.Routes)
+		if err != nil {
+			return "", err
+		}
+		scheme, host = routeLocation(route)
+	}
+	if opts.Scheme != "" {
+		scheme = opts.Scheme
+	}
+	if scheme != "http" && scheme != "https" {
+		return "", NewError(ErrGeneric, nil, "Unsupported scheme %s, use http or https", scheme)
+	}
+	return scheme + "://" + strings.TrimRight(host, "/"), nil
+}
+
+// Only HTTP routes go through the gorouter, which can pin a request to an instance
+func routedByGorouter(app plugin_models.GetAppModel, opts ControllerOptions) bool {
+	if opts.Endpoint != "" {
+		return false
+	}
+	route, err := preferredRoute(app.Routes)
+	return err == nil && route.Port == 0
+}
+
+func preferredRoute(mapped []plugin_models.GetApp_RouteSummary) (plugin_models.GetApp_RouteSummary, error) {
+	routes := []plugin_models.GetApp_RouteSummary{}
+	for _, route := range mapped {
+		if route.Domain.Name != "" && route.Domain.Name != internalDomain {
+			routes = append(routes, route)
+		}
+	}
+	if len(routes) == 0 {
+		return plugin_models.GetApp_RouteSummary{}, NewError(ErrNoRoute, nil, "Could not find usable route for this app. Make sure at least one route is mapped to this app")
+	}
+	sort.SliceStable(routes, func(i, j int) bool {
+		return routePreference(routes[i]) < routePreference(routes[j])
+	})
+	return routes[0], nil
+}
+
+func routePreference(route plugin_models.GetApp_RouteSummary) int {
+	switch {
+	case route.Port > 0:
+		return 2
+	case route.Path != "":
+		return 1
+	default:
+		return 0
+	}
+}
+
+// TCP routes bypass the TLS terminating HTTP router and reach the container
+// directly. They still default to https since every request carries the
+// credentials, plain http has to be asked for with --scheme http.
+func routeLocation(route plugin_models.GetApp_RouteSummary) (string, string) {
+	if route.Port > 0 {
+		return "https", route.Domain.Name + ":" + strconv.Itoa(route.Port)
+	}
+	host := route.Domain.Name
+	if route.Host != "" {
+		host = route.Host + "." + host
+	}
+	return "https", host + strings.TrimRight(route.Path, "/")
+}
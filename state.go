@@ -0,0 +1,53 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+/*
+*	stateDir holds what the plugin remembers between runs: the checksum cache,
+*	the baselines and the push history. It lives next to the CLI configuration
+*	in $CF_HOME/.cf/fastpush, or ~/.cf/fastpush, and not in the app root where
+*	cf push would upload it along with the app.
+ */
+func stateDir() string {
+	home := os.Getenv("CF_HOME")
+	if home == "" {
+		home = userHomeDir()
+	}
+	return filepath.Join(home, ".cf", "fastpush")
+}
+
+// appStateDir holds the baseline and the push history of an app
+func appStateDir(appGuid string) string {
+	return filepath.Join(stateDir(), "apps", appGuid)
+}
+
+// rootStateDir holds the checksum cache of the app root in the working
+// directory, roots are told apart by a hash of their absolute path
+func rootStateDir() string {
+	root, err := filepath.Abs(".")
+	if err == nil {
+		if resolved, err := filepath.EvalSymlinks(root); err == nil {
+			root = resolved
+		}
+	}
+	sum := md5.Sum([]byte(root))
+	return filepath.Join(stateDir(), "roots", hex.EncodeToString(sum[:]))
+}
+
+// Same lookup as the CLI uses for its configuration
+func userHomeDir() string {
+	if runtime.GOOS == "windows" {
+		home := os.Getenv("HOMEDRIVE") + os.Getenv("HOMEPATH")
+		if home == "" {
+			home = os.Getenv("USERPROFILE")
+		}
+		return home
+	}
+	return os.Getenv("HOME")
+}
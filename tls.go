@@ -0,0 +1,70 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net/url"
+
+	"code.cloudfoundry.org/cli/plugin"
+)
+
+/*
+*	TLSConfig builds the TLS settings for the controller connection. Validation
+*	is skipped when the CLI was logged in with --skip-ssl-validation, a CA
+*	bundle is trusted on top of the system roots and a client certificate is
+*	presented for mutual TLS.
+ */
+func (c *FastPushPlugin) TLSConfig(cliConnection plugin.CliConnection, opts ControllerOptions) (*tls.Config, error) {
+	skipValidation, err := cliConnection.IsSSLDisabled()
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{InsecureSkipVerify: skipValidation}
+
+	if opts.CACert != "" {
+		pem, err := ioutil.ReadFile(opts.CACert)
+		if err != nil {
+			return nil, NewError(ErrCertificate, err, "Could not read CA bundle %s", opts.CACert)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, NewError(ErrCertificate, nil, "No PEM certificates found in CA bundle %s", opts.CACert)
+		}
+		config.RootCAs = pool
+	}
+
+	if opts.ClientCert != "" || opts.ClientKey != "" {
+		if opts.ClientCert == "" || opts.ClientKey == "" {
+			return nil, NewError(ErrCertificate, nil, "Both a client certificate and a client key are needed for mutual TLS")
+		}
+		cert, err := tls.LoadX509KeyPair(opts.ClientCert, opts.ClientKey)
+		if err != nil {
+			return nil, NewError(ErrCertificate, err, "Could not load client certificate %s", opts.ClientCert)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// isCertificateError reports whether a request failed on certificate validation
+func isCertificateError(err error) bool {
+	for err != nil {
+		switch e := err.(type) {
+		case x509.UnknownAuthorityError, x509.CertificateInvalidError, x509.HostnameError:
+			return true
+		case *url.Error:
+			err = e.Err
+		case interface {
+			Unwrap() error
+		}:
+			err = e.Unwrap()
+		default:
+			return false
+		}
+	}
+	return false
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+
+	"code.cloudfoundry.org/cli/cf/formatters"
+	"github.com/parnurzeal/gorequest"
+	"github.com/xiwenc/cf-fastpush-controller/lib"
+)
+
+// Upload limits per PUT /files request. A single file larger than
+// maxBatchBytes is sent on its own, streamed like every other file.
+const (
+	maxBatchBytes = 8 * 1024 * 1024
+	maxBatchFiles = 500
+	// Attempts in total, not retries
+	batchAttempts = 3
+)
+
+type uploadBatch struct {
+	paths []string
+	bytes int64
+}
+
+// planBatches splits the files into batches bounded by maxBytes and maxFiles
+func planBatches(files map[string]*FileEntry, mapper *PathMapper, maxBytes int64, maxFiles int) []*uploadBatch {
+	paths := make([]string, 0, len(files))
+	for path := range files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	batches := []*uploadBatch{}
+	current := &uploadBatch{}
+	for _, path := range paths {
+		size := uploadSize(mapper.LocalPath(path), files[path])
+		if len(current.paths) > 0 && (current.bytes+size > maxBytes || len(current.paths) >= maxFiles) {
+			batches = append(batches, current)
+			current = &uploadBatch{}
+		}
+		current.paths = append(current.paths, path)
+		current.bytes += size
+	}
+	// An empty change set still sends one request, the controller answers it with its status
+	return append(batches, current)
+}
+
+/*
+*	UploadFiles sends the files to the controller in bounded batches. File
+*	contents are streamed from disk while their batch is sent, so memory usage
+*	grows neither with the size of the change set nor with the size of a
+*	single file. The restart decision is sent along, the controller acts on it
+*	once the last batch arrived.
+ */
+func (c *FastPushPlugin) UploadFiles(client *ControllerClient, files map[string]*FileEntry, restart string) (*lib.Status, error) {
+	format := formatJSON
+	if len(files) > 0 {
+		format = c.UploadFormat(client)
+	}
+	batches := planBatches(files, c.paths, maxBatchBytes, maxBatchFiles)
+	body := ""
+	for i, batch := range batches {
+		label := fmt.Sprintf("%d/%d", i+1, len(batches))
+		if len(batches) > 1 {
+			c.ui.Say("Uploading batch %s (%d files, %s)", label, len(batch.paths), formatters.ByteSize(batch.bytes))
+		}
+		batch := batch
+		write := func(w io.Writer) error {
+			if format == formatTarGz {
+				return writeBatchArchive(w, files, c.paths, batch)
+			}
+			return writeBatchJSON(w, files, c.paths, batch)
+		}
+		var err error
+		body, err = c.putBatch(client, write, format, label, restart)
+		if err != nil {
+			return nil, err
+		}
+	}
+	status := &lib.Status{}
+	if err := json.Unmarshal([]byte(body), status); err != nil {
+		return nil, NewError(ErrDecode, err, "Could not decode status from the fast-push controller")
+	}
+	return status, nil
+}
+
+/*
+*	writeBatchJSON writes the batch as the JSON map of FileEntry the controller
+*	expects. Contents are base64 encoded on the fly instead of being marshaled
+*	from memory.
+ */
+func writeBatchJSON(w io.Writer, files map[string]*FileEntry, mapper *PathMapper, batch *uploadBatch) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString("{")
+	for i, path := range batch.paths {
+		entry := files[path]
+		if i > 0 {
+			bw.WriteString(",")
+		}
+		key, _ := json.Marshal(path)
+		checksum, _ := json.Marshal(entry.Checksum)
+		fmt.Fprintf(bw, "%s:{\"Checksum\":%s,\"Content\":", key, checksum)
+		if err := writeContent(bw, entry, mapper.LocalPath(path)); err != nil {
+			return err
+		}
+		if entry.Mode != 0 {
+			fmt.Fprintf(bw, ",\"Mode\":%d", entry.Mode)
+		}
+		if entry.Link != "" {
+			link, _ := json.Marshal(entry.Link)
+			fmt.Fprintf(bw, ",\"Link\":%s", link)
+		}
+		bw.WriteString("}")
+	}
+	bw.WriteString("}")
+	return bw.Flush()
+}
+
+// writeContent writes the content as a JSON string, null for links
+func writeContent(w io.Writer, entry *FileEntry, localPath string) error {
+	if entry.Link != "" {
+		_, err := io.WriteString(w, "null")
+		return err
+	}
+	var r io.Reader = bytes.NewReader(entry.Content)
+	if entry.Content == nil {
+		f, err := os.Open(localPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	io.WriteString(w, "\"")
+	encoder := base64.NewEncoder(base64.StdEncoding, w)
+	if _, err := io.Copy(encoder, r); err != nil {
+		return err
+	}
+	if err := encoder.Close(); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\"")
+	return err
+}
+
+// putBatch sends one batch, retrying when the controller could not be reached
+// or answered with a server error. The batch is written again for every
+// attempt.
+func (c *FastPushPlugin) putBatch(client *ControllerClient, write func(io.Writer) error, format string, label string, restart string) (string, error) {
+	header := http.Header{}
+	header.Set("x-fastpush-batch", label)
+	header.Set("x-fastpush-restart", restart)
+	header.Set("Content-Type", "application/json")
+	if format == formatTarGz {
+		header.Set("Content-Type", "application/gzip")
+	}
+	var err error
+	for attempt := 1; attempt <= batchAttempts; attempt++ {
+		response, body, errs, werr := client.Stream("PUT", "/files", header, write)
+		if werr != nil {
+			return "", NewError(ErrGeneric, werr, "Could not read the files of batch %s", label)
+		}
+		err = checkResponse(response, errs, "uploading files")
+		if err == nil {
+			return body, nil
+		}
+		if !isRetryable(response, err) || attempt == batchAttempts {
+			break
+		}
+		c.ui.Warn("warning: batch %s failed (attempt %d/%d), retrying: %s", label, attempt, batchAttempts, err.Error())
+		time.Sleep(time.Duration(attempt) * time.Second)
+	}
+	return "", err
+}
+
+// Only failures that may pass are retried: the controller could not be reached
+// or answered with a server error. A rejected batch would be rejected again.
+func isRetryable(response gorequest.Response, err error) bool {
+	e, ok := err.(*FastPushError)
+	if !ok {
+		return false
+	}
+	switch e.Kind {
+	case ErrControllerUnreachable:
+		return true
+	case ErrUnexpectedStatus:
+		return response != nil && response.StatusCode >= http.StatusInternalServerError
+	}
+	return false
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func sized(size int) *FileEntry {
+	return &FileEntry{Checksum: "x", Content: make([]byte, size)}
+}
+
+func TestPlanBatches(t *testing.T) {
+	files := map[string]*FileEntry{
+		"a": sized(3),
+		"b": sized(3),
+		"c": sized(3),
+		"d": sized(20),
+		"e": sized(1),
+		"f": {Checksum: "y", Link: "a"},
+	}
+	batches := planBatches(files, nil, 8, 3)
+	got := [][]string{}
+	for _, batch := range batches {
+		got = append(got, batch.paths)
+	}
+	// A file larger than the limit is sent on its own
+	want := [][]string{{"a", "b"}, {"c"}, {"d"}, {"e", "f"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got batches %v, want %v", got, want)
+	}
+	if batches[0].bytes != 6 || batches[3].bytes != 1 {
+		t.Errorf("got batch sizes %d and %d, want 6 and 1", batches[0].bytes, batches[3].bytes)
+	}
+
+	if got := planBatches(files, nil, 100, 2); len(got) != 3 {
+		t.Errorf("6 files in batches of 2 gave %d batches", len(got))
+	}
+	if got := planBatches(map[string]*FileEntry{}, nil, 8, 3); len(got) != 1 || len(got[0].paths) != 0 {
+		t.Errorf("an empty change set must still send one empty batch, got %v", got)
+	}
+}
+
+func TestWriteBatchJSON(t *testing.T) {
+	inTempDir(t, func() {
+		os.Mkdir("static", 0755)
+		if err := ioutil.WriteFile("static/index.html", []byte("<html/>"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		mapper, err := NewPathMapper([]PathMapping{{Local: "static", Remote: "/"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		files := map[string]*FileEntry{
+			"index.html": {Checksum: "1"},
+			"run.sh":     {Checksum: "2", Content: []byte("#!/bin/sh\n"), Mode: 0755},
+			"latest":     {Checksum: "3", Link: "run.sh"},
+			"other.txt":  {Checksum: "4", Content: []byte("not in this batch")},
+		}
+		var buf bytes.Buffer
+		batch := &uploadBatch{paths: []string{"index.html", "latest", "run.sh"}}
+		if err := writeBatchJSON(&buf, files, mapper, batch); err != nil {
+			t.Fatal(err)
+		}
+		decoded := map[string]*FileEntry{}
+		if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+			t.Fatalf("invalid JSON %s: %s", buf.String(), err.Error())
+		}
+		want := map[string]*FileEntry{
+			"index.html": {Checksum: "1", Content: []byte("<html/>")},
+			"run.sh":     {Checksum: "2", Content: []byte("#!/bin/sh\n"), Mode: 0755},
+			"latest":     {Checksum: "3", Link: "run.sh"},
+		}
+		if !reflect.DeepEqual(decoded, want) {
+			t.Errorf("got %s", buf.String())
+		}
+	})
+}
+
+func TestWriteBatchJSONMissingFile(t *testing.T) {
+	inTempDir(t, func() {
+		files := map[string]*FileEntry{"gone.txt": {Checksum: "1"}}
+		err := writeBatchJSON(ioutil.Discard, files, nil, &uploadBatch{paths: []string{"gone.txt"}})
+		if err == nil {
+			t.Errorf("a file removed since the scan must fail the batch")
+		}
+	})
+}
+
+func TestIsRetryable(t *testing.T) {
+	for _, c := range []struct {
+		status    int
+		err       error
+		retryable bool
+	}{
+		{0, NewError(ErrControllerUnreachable, errors.New("connection refused"), "uploading"), true},
+		{http.StatusBadGateway, NewError(ErrUnexpectedStatus, nil, "uploading"), true},
+		{http.StatusInternalServerError, NewError(ErrUnexpectedStatus, nil, "uploading"), true},
+		{http.StatusBadRequest, NewError(ErrUnexpectedStatus, nil, "uploading"), false},
+		{http.StatusNotFound, NewError(ErrUnexpectedStatus, nil, "uploading"), false},
+		{http.StatusRequestEntityTooLarge, NewError(ErrUnexpectedStatus, nil, "uploading"), false},
+		{http.StatusUnauthorized, NewError(ErrAuthRejected, nil, "uploading"), false},
+		{0, NewError(ErrCertificate, errors.New("unknown authority"), "uploading"), false},
+	} {
+		var response *http.Response
+		if c.status != 0 {
+			response = &http.Response{StatusCode: c.status}
+		}
+		if got := isRetryable(response, c.err); got != c.retryable {
+			t.Errorf("status %d, %s: retryable %v, want %v", c.status, c.err.Error(), got, c.retryable)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultWaitTimeout = 2 * time.Minute
+	waitInterval       = 2 * time.Second
+	// Healthy polls in a row that count as restarted when the restart itself
+	// was not seen, the controller may restart the app some time after the upload
+	restartSettlePolls = 3
+)
+
+// Health values of the controller status that mean the app is up
+var healthyStates = []string{"running", "healthy", "ok", "up"}
+
+type WaitOptions struct {
+	Enabled   bool
+	Timeout   time.Duration
+	HealthURL string
+}
+
+/*
+*	Push runs a SyncInstances cycle and, when asked to, waits until the app
+*	came up again. The error tells whether the new code is actually running.
+ */
+func (c *FastPushPlugin) Push(client *ControllerClient, appName string, opts FastPushOptions, paths map[string]bool) error {
+	restarted, err := c.SyncInstances(client, appName, opts, paths)
+	if err != nil {
+		return err
+	}
+	if !opts.Wait.Enabled || opts.DryRun {
+		return nil
+	}
+	return c.WaitHealthy(client, opts.Wait, restarted)
+}
+
+/*
+*	WaitHealthy polls every instance until all of them report a healthy status
+*	and, if a health URL is given, answer it with a 2xx status. Without a
+*	restart an instance is done once it is healthy. After a restart the first
+*	healthy answers may still come from the old process, so an instance is
+*	only done when it was seen unhealthy and recovered, or stayed healthy for
+*	restartSettlePolls polls in a row. Fails with ErrUnhealthy when the
+*	timeout expires first.
+ */
+func (c *FastPushPlugin) WaitHealthy(client *ControllerClient, opts WaitOptions, restarted bool) error {
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = defaultWaitTimeout
+	}
+	c.ui.Say("Waiting up to %s for the app to become healthy", timeout)
+
+	started := time.Now()
+	pending := client.InstanceClients()
+	problems := map[int]string{}
+	// Healthy polls in a row per instance, -1 once it was seen restarting
+	healthy := map[int]int{}
+	deadline := time.Now().Add(timeout)
+	for {
+		waiting := []*ControllerClient{}
+		for _, target := range pending {
+			if problem := instanceProblem(target, opts.HealthURL); problem != "" {
+				problems[target.Instance] = problem
+				healthy[target.Instance] = -1
+				waiting = append(waiting, target)
+				continue
+			}
+			if !restarted || healthy[target.Instance] < 0 {
+				continue
+			}
+			healthy[target.Instance]++
+			if healthy[target.Instance] < restartSettlePolls {
+				problems[target.Instance] = "restart not seen yet"
+				waiting = append(waiting, target)
+			}
+		}
+		pending = waiting
+		if len(pending) == 0 {
+			c.ui.Say("App is healthy")
+			c.report.Event(&Event{Type: "wait", OK: boolValue(true), DurationMs: millisSince(started)})
+			return nil
+		}
+		if time.Now().Add(waitInterval).After(deadline) {
+			break
+		}
+		time.Sleep(waitInterval)
+	}
+
+	table := c.ui.Table([]string{"instance", "problem"})
+	for _, target := range pending {
+		table.Add(instanceName(target), problems[target.Instance])
+	}
+	table.Print()
+	c.report.Event(&Event{Type: "wait", OK: boolValue(false), DurationMs: millisSince(started)})
+	return NewError(ErrUnhealthy, nil, "App did not become healthy within %s", timeout)
+}
+
+// instanceProblem returns why an instance is not healthy yet, or "" when it is
+func instanceProblem(client *ControllerClient, healthURL string) string {
+	status, err := client.Status()
+	if err != nil {
+		return err.Error()
+	}
+	if !isHealthy(status.Health) {
+		return fmt.Sprintf("health is %q", status.Health)
+	}
+	if healthURL == "" {
+		return ""
+	}
+	response, _, errs := client.GetApp(healthURL).End()
+	if len(errs) > 0 {
+		return errs[0].Error()
+	}
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Sprintf("%s answered %s", healthURL, response.Status)
+	}
+	return ""
+}
+
+func isHealthy(health string) bool {
+	for _, state := range healthyStates {
+		if strings.EqualFold(strings.TrimSpace(health), state) {
+			return true
+		}
+	}
+	return false
+}
+
+func instanceName(client *ControllerClient) string {
+	if client.Instance == anyInstance {
+		return "any"
+	}
+	return strconv.Itoa(client.Instance)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"code.cloudfoundry.org/cli/plugin"
+	"gopkg.in/fsnotify.v1"
+)
+
+// Editors usually write a file in several steps, wait for things to settle
+// before pushing.
+const watchDebounce = 500 * time.Millisecond
+
+/*
+*	Watch keeps the plugin running and pushes the files that changed in the
+*	working tree. Bursts of events are collected until the tree has been quiet
+*	for watchDebounce, then a single SyncInstances cycle runs for those paths.
+*	It only returns when the watcher itself fails.
+ */
+func (c *FastPushPlugin) Watch(cliConnection plugin.CliConnection, client *ControllerClient, appName string, opts FastPushOptions) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	ignore, err := LoadIgnoreRules(".")
+	if err != nil {
+		return err
+	}
+	if err := watchTree(watcher, ".", ignore); err != nil {
+		return err
+	}
+	c.ui.Say("Watching for changes in %s, press Ctrl-C to stop", currentDir())
+
+	changed := map[string]bool{}
+	var settled <-chan time.Time
+	for {
+		select {
+		case event := <-watcher.Events:
+			// Chmod counts too, a permission change is pushed like any other
+			path := filepath.Clean(event.Name)
+			info, statErr := os.Stat(path)
+			isDir := statErr == nil && info.IsDir()
+			if ignore.Ignored(path, isDir) {
+				continue
+			}
+			if isDir && event.Op&fsnotify.Create == fsnotify.Create {
+				watchTree(watcher, path, ignore)
+			}
+			changed[path] = true
+			settled = time.After(watchDebounce)
+		case err := <-watcher.Errors:
+			c.ui.Warn("warning: %s", err.Error())
+		case <-settled:
+			paths := changed
+			changed = map[string]bool{}
+			settled = nil
+			c.ui.Say("")
+			c.ui.Say("Detected changes in %d path(s), pushing", len(paths))
+			if err := c.syncChanges(cliConnection, client, appName, opts, paths); err != nil {
+				// The app may just be restarting, retry these paths with the next change
+				c.ui.Warn("warning: %s", err.Error())
+				for path := range paths {
+					changed[path] = true
+				}
+			}
+		}
+	}
+}
+
+// Access tokens expire during long sessions, get a fresh one every cycle
+func (c *FastPushPlugin) syncChanges(cliConnection plugin.CliConnection, client *ControllerClient, appName string, opts FastPushOptions, paths map[string]bool) error {
+	if err := c.RefreshCredentials(cliConnection, client); err != nil {
+		return err
+	}
+	return c.Push(client, appName, opts, paths)
+}
+
+// fsnotify is not recursive, every directory needs its own watch
+func watchTree(watcher *fsnotify.Watcher, root string, ignore *IgnoreRules) error {
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		if path != root && ignore.Ignored(path, true) {
+			return filepath.SkipDir
+		}
+		return watcher.Add(path)
+	})
+}
+
+// filterFiles keeps the entries that are one of paths or live below one of them
+func filterFiles(files map[string]*FileEntry, paths map[string]bool) map[string]*FileEntry {
+	filtered := map[string]*FileEntry{}
+	for path, f := range files {
+		for p := filepath.Clean(path); ; p = filepath.Dir(p) {
+			if paths[p] {
+				filtered[path] = f
+				break
+			}
+			if p == filepath.Dir(p) {
+				break
+			}
+		}
+	}
+	return filtered
+}
+
+func currentDir() string {
+	dir, err := os.Getwd()
+	if err != nil {
+		return "."
+	}
+	return dir
+}